@@ -4,11 +4,15 @@ go 1.24.3
 
 require (
 	github.com/caarlos0/env/v11 v11.3.1
+	github.com/coder/websocket v1.8.14
 	github.com/google/uuid v1.6.0
 	github.com/pocketbase/dbx v1.11.0
 	github.com/pocketbase/pocketbase v0.28.4
 	github.com/sashabaranov/go-openai v1.40.3
 	go.mongodb.org/mongo-driver/v2 v2.2.2
+	golang.org/x/crypto v0.39.0
+	golang.org/x/image v0.28.0
+	golang.org/x/net v0.41.0
 	gopkg.in/telebot.v4 v4.0.0-beta.5
 )
 
@@ -36,10 +40,7 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
-	golang.org/x/image v0.28.0 // indirect
-	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
 	golang.org/x/sync v0.15.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
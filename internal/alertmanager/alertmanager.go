@@ -0,0 +1,112 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Statuses of webhook groups and alerts
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+type Alertmanager struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Webhook is the version 4 payload of the Alertmanager webhook receiver
+type Webhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Matcher selects alerts of a silence by label
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+type Silence struct {
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+func New(baseURL string) *Alertmanager {
+	return &Alertmanager{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Enabled reports whether the API URL is configured
+func (am *Alertmanager) Enabled() bool {
+	return am.baseURL != ""
+}
+
+// CreateSilence creates a silence through the v2 API and returns its ID
+func (am *Alertmanager) CreateSilence(silence Silence) (string, error) {
+	data, err := json.Marshal(silence)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := am.client.Post(am.baseURL+"/api/v2/silences", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to create silence: %s - %s", resp.Status, string(body))
+	}
+
+	var result struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.SilenceID, nil
+}
+
+// EqualMatchers returns exact matchers for the labels
+func EqualMatchers(labels map[string]string) []Matcher {
+	matchers := make([]Matcher, 0, len(labels))
+	for name, value := range labels {
+		matchers = append(matchers, Matcher{Name: name, Value: value, IsEqual: true})
+	}
+	return matchers
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/biozz/biozz-dev-bot/internal/gpts"
+	"github.com/pocketbase/dbx"
+	tele "gopkg.in/telebot.v4"
+)
+
+const defaultTranslateLanguage = "English"
+
+// defaultPrompts are used when a prompt is missing from the prompts collection
+var defaultPrompts = map[string]string{
+	"tldr":      "Summarize the following text in a few short bullet points. Answer in the language of the text.\n\n{{.Text}}",
+	"translate": "Translate the following text to {{.Lang}}. Reply with the translation only.\n\n{{.Text}}",
+	"explain":   "Explain the following text in simple terms, keep it short. Answer in the language of the text.\n\n{{.Text}}",
+	"fix":       "Fix grammar, spelling and punctuation in the following text, keep the original language and tone. Reply with the corrected text only.\n\n{{.Text}}",
+	"read":      "Summarize the following web page in a few short bullet points. Answer in the language of the page.\n\nTitle: {{.Title}}\nURL: {{.URL}}\n\n{{.Text}}",
+}
+
+type promptData struct {
+	Text  string
+	Lang  string
+	Title string
+	URL   string
+}
+
+// handleQuickAction runs the replied-to message through the named prompt
+// and replies in place, the active LibreChat conversation is not touched.
+func (b *Bot) handleQuickAction(name string) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		data := promptData{Lang: defaultTranslateLanguage}
+		payload := c.Message().Payload
+
+		if name == "translate" {
+			args := strings.SplitN(payload, " ", 2)
+			if args[0] != "" {
+				data.Lang = args[0]
+			}
+			payload = ""
+			if len(args) > 1 {
+				payload = args[1]
+			}
+		}
+
+		data.Text = repliedText(c.Message())
+		if data.Text == "" {
+			data.Text = strings.TrimSpace(payload)
+		}
+		if data.Text == "" {
+			return c.Reply("Reply to a message to use /" + name)
+		}
+
+		prompt, err := b.renderPrompt(name, data)
+		if err != nil {
+			b.app.Logger().Error("Error rendering prompt", "error", err, "prompt", name)
+			return c.Reply("❌ Unable to render prompt")
+		}
+
+		c.Notify(tele.Typing)
+
+		provider := gpts.NewProvider(gpts.OpenAI, b.openAIAPIKey)
+		resp, err := provider.CreateChatCompletion(
+			context.Background(),
+			gpts.ChatCompletionRequest{
+				Model: b.summaryModel,
+				Messages: []gpts.ChatCompletionMessage{
+					{
+						Role:    string(gpts.RoleUser),
+						Content: prompt,
+					},
+				},
+			},
+		)
+		if err != nil {
+			return c.Reply("chat completion error: " + err.Error())
+		}
+
+		return c.Reply(truncate(resp.Message.Content, messageLimit))
+	}
+}
+
+// renderPrompt executes the prompt template stored in the prompts
+// collection, falling back to the built-in default.
+func (b *Bot) renderPrompt(name string, data any) (string, error) {
+	text := defaultPrompts[name]
+
+	record, err := b.app.FindFirstRecordByFilter("prompts", "name = {:name}", dbx.Params{"name": name})
+	switch {
+	case err == nil:
+		text = record.GetString("template")
+	case !errors.Is(err, sql.ErrNoRows):
+		b.app.Logger().Warn("Error finding prompt, using default", "error", err, "prompt", name)
+	}
+
+	return executeTemplate(name, text, data)
+}
+
+func executeTemplate(name string, text string, data any) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
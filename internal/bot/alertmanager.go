@@ -0,0 +1,360 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/biozz-dev-bot/internal/alertmanager"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// alertmanagerSilence is the duration of the silence button
+	alertmanagerSilence = time.Hour
+	// alertmanagerAlertLimit caps the number of alerts listed in a message
+	alertmanagerAlertLimit = 10
+	// alertmanagerLabelsLimit caps the length of the common labels line
+	alertmanagerLabelsLimit = 300
+)
+
+// handleAlertmanager receives Alertmanager webhooks. A group is posted once
+// and its message is edited on every update of the group, including the
+// resolve, until a resolved group fires again.
+func (b *Bot) handleAlertmanager(e *core.RequestEvent) error {
+	if !validToken(e.Request, b.alertmanagerToken) {
+		return e.UnauthorizedError("Invalid token", nil)
+	}
+
+	var webhook alertmanager.Webhook
+	if err := e.BindBody(&webhook); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if webhook.GroupKey == "" {
+		return e.BadRequestError("groupKey is required", nil)
+	}
+	if webhook.Version != "4" {
+		b.app.Logger().Warn("Unexpected Alertmanager webhook version", "version", webhook.Version)
+	}
+
+	threadID, _ := strconv.Atoi(e.Request.URL.Query().Get("thread_id"))
+
+	msg, err := b.postAlertGroup(webhook, threadID)
+	if errors.Is(err, errAlertMuted) {
+		return e.JSON(http.StatusOK, map[string]any{"skipped": true})
+	}
+	if err != nil {
+		b.app.Logger().Error("Error posting Alertmanager group", "error", err, "group", webhook.GroupKey)
+		return e.InternalServerError("Failed to post alerts", nil)
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{"message_id": msg.ID})
+}
+
+// postAlertGroup edits the message of a firing group or posts a new one,
+// the group is tracked by its key in the alertmanager_groups collection
+func (b *Bot) postAlertGroup(webhook alertmanager.Webhook, threadID int) (*tele.Message, error) {
+	b.alertmanagerMu.Lock()
+	defer b.alertmanagerMu.Unlock()
+
+	record, err := b.app.FindFirstRecordByFilter("alertmanager_groups", "group_key = {:key}", dbx.Params{"key": webhook.GroupKey})
+	if err != nil {
+		collection, err := b.app.FindCollectionByNameOrId("alertmanager_groups")
+		if err != nil {
+			return nil, err
+		}
+		record = core.NewRecord(collection)
+		record.Set("group_key", webhook.GroupKey)
+	}
+
+	// A group firing again after its resolve is a new incident
+	existing := record.GetInt("message_id") != 0 && record.GetString("status") == alertmanager.StatusFiring
+	if !existing {
+		// The message of the last incident is never edited again, even when
+		// the new one is muted and not posted
+		record.Set("silenced_until", nil)
+		record.Set("chat_id", 0)
+		record.Set("thread_id", 0)
+		record.Set("message_id", 0)
+	}
+
+	labels := webhook.GroupLabels
+	if len(labels) == 0 {
+		labels = webhook.CommonLabels
+	}
+	record.Set("receiver", webhook.Receiver)
+	record.Set("status", webhook.Status)
+	record.Set("labels", labels)
+	if err := b.app.Save(record); err != nil {
+		return nil, err
+	}
+
+	text := formatAlertGroup(webhook)
+	if until := record.GetDateTime("silenced_until").Time(); until.After(time.Now()) {
+		text += fmt.Sprintf("\n\n🔕 Silenced until %s", until.Local().Format("15:04"))
+	}
+
+	// The alert of the incident shares the group key
+	var incident *core.Record
+	if existing {
+		incident, _ = b.latestAlert(alertSourceAlertmanager, webhook.GroupKey)
+	}
+	if incident != nil {
+		if webhook.Status == alertmanager.StatusResolved {
+			b.resolveAlert(incident, "Alertmanager", true)
+		}
+		if lines := alertStatusLines(incident); len(lines) > 0 {
+			text += "\n\n" + strings.Join(lines, "\n")
+		}
+	}
+
+	keyboard := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	if webhook.Status == alertmanager.StatusFiring && b.alertmanager.Enabled() {
+		rows = append(rows, keyboard.Row(keyboard.Data("🔕 Silence 1h", "am:silence:"+record.Id)))
+	}
+
+	var msg *tele.Message
+	if existing {
+		if incident != nil {
+			if row := alertRow(keyboard, incident); len(row) > 0 {
+				rows = append(rows, row)
+			}
+		}
+		keyboard.Inline(rows...)
+
+		stored := tele.StoredMessage{MessageID: strconv.Itoa(record.GetInt("message_id")), ChatID: int64(record.GetInt("chat_id"))}
+		opts := &tele.SendOptions{ParseMode: tele.ModeHTML, ReplyMarkup: keyboard, DisableWebPagePreview: true}
+		msg, err = b.bot.Edit(stored, text, opts)
+		if errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+			return &tele.Message{ID: record.GetInt("message_id")}, nil
+		}
+		if err != nil {
+			// The message could have been deleted, the group is posted again
+			b.app.Logger().Warn("Error editing Alertmanager message", "error", err, "group", webhook.GroupKey)
+		} else if incident != nil {
+			b.saveAlertContent(incident, msg)
+		}
+	}
+
+	if msg == nil {
+		loc := b.alertsLocation(threadID)
+		if webhook.Status == alertmanager.StatusFiring {
+			msg, _, err = b.postAlert(alert{
+				source:   alertSourceAlertmanager,
+				key:      webhook.GroupKey,
+				severity: webhook.CommonLabels["severity"],
+				title:    "Alertmanager: " + alertGroupName(webhook),
+				loc:      loc,
+				text:     text,
+				mode:     tele.ModeHTML,
+				rows:     rows,
+			})
+		} else {
+			// Resolved groups without a message have nothing to acknowledge
+			opts := loc.sendOptions(nil)
+			opts.ParseMode = tele.ModeHTML
+			opts.DisableWebPagePreview = true
+			msg, err = b.bot.Send(loc.recipient(), text, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		record.Set("chat_id", loc.chatID)
+		record.Set("thread_id", loc.threadID)
+		record.Set("message_id", msg.ID)
+		if err := b.app.Save(record); err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// formatAlertGroup renders the group status, common labels and the alerts
+// of the group with their summaries
+func formatAlertGroup(webhook alertmanager.Webhook) string {
+	firing := 0
+	for _, alert := range webhook.Alerts {
+		if alert.Status == alertmanager.StatusFiring {
+			firing++
+		}
+	}
+
+	name := alertGroupName(webhook)
+	header := fmt.Sprintf("🔥 <b>FIRING:%d</b> %s", firing, html.EscapeString(name))
+	if webhook.Status == alertmanager.StatusResolved {
+		header = fmt.Sprintf("✅ <b>RESOLVED</b> %s", html.EscapeString(name))
+	}
+	lines := []string{header}
+
+	if labels := sortedLabels(webhook.CommonLabels, "alertname"); len(labels) > 0 {
+		lines = append(lines, formatCommonLabels(labels))
+	}
+	if summary := alertSummary(webhook.CommonAnnotations); summary != "" {
+		lines = append(lines, "", html.EscapeString(truncate(summary, 500)))
+	}
+	lines = append(lines, "")
+
+	var footer string
+	if webhook.ExternalURL != "" {
+		footer = fmt.Sprintf("\n\n<a href=\"%s\">Alertmanager</a>", html.EscapeString(webhook.ExternalURL))
+	}
+
+	// Whole alerts are dropped once the message would outgrow the limit, so
+	// the markup of a line is never cut
+	length := len(strings.Join(lines, "\n")) + len(footer)
+	for i, alert := range webhook.Alerts {
+		line := formatAlert(alert, webhook.CommonLabels, len(webhook.CommonAnnotations) > 0)
+		if i == alertmanagerAlertLimit || length+len(line) > messageLimit {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(webhook.Alerts)-i+webhook.TruncatedAlerts))
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+		if i == len(webhook.Alerts)-1 && webhook.TruncatedAlerts > 0 {
+			lines = append(lines, fmt.Sprintf("… and %d more", webhook.TruncatedAlerts))
+		}
+	}
+
+	return strings.Join(lines, "\n") + footer
+}
+
+// formatCommonLabels renders the labels shared by the group, labels that
+// don't fit within alertmanagerLabelsLimit are counted instead
+func formatCommonLabels(labels []string) string {
+	var parts []string
+	length := 0
+	for i, label := range labels {
+		if i > 0 && length+len(label) > alertmanagerLabelsLimit {
+			parts = append(parts, fmt.Sprintf("+%d more", len(labels)-i))
+			break
+		}
+		parts = append(parts, "<code>"+html.EscapeString(truncate(label, alertmanagerLabelsLimit))+"</code>")
+		length += len(label) + 1
+	}
+	return strings.Join(parts, " ")
+}
+
+// alertGroupName returns the common alert name, falling back to the group labels
+func alertGroupName(webhook alertmanager.Webhook) string {
+	if name := webhook.CommonLabels["alertname"]; name != "" {
+		return name
+	}
+	return strings.Join(sortedLabels(webhook.GroupLabels, ""), " ")
+}
+
+// formatAlert renders an alert with the labels that differ from the group,
+// its summary unless the group shares one and how long it has been firing
+func formatAlert(alert alertmanager.Alert, common map[string]string, commonSummary bool) string {
+	icon := "🔴"
+	if alert.Status == alertmanager.StatusResolved {
+		icon = "🟢"
+	}
+
+	var parts []string
+	if labels := sortedLabels(alert.Labels, "alertname"); len(labels) > 0 {
+		var own []string
+		for _, label := range labels {
+			name, value, _ := strings.Cut(label, "=")
+			if common[name] != value {
+				own = append(own, label)
+			}
+		}
+		if len(own) > 0 {
+			parts = append(parts, html.EscapeString(truncate(strings.Join(own, " "), 150)))
+		}
+	}
+	if summary := alertSummary(alert.Annotations); summary != "" && !commonSummary {
+		parts = append(parts, html.EscapeString(truncate(summary, 200)))
+	}
+
+	since := "since " + alert.StartsAt.Local().Format("Jan 2 15:04")
+	if alert.Status == alertmanager.StatusResolved && !alert.EndsAt.IsZero() {
+		since = "resolved after " + formatDuration(alert.EndsAt.Sub(alert.StartsAt))
+	}
+	parts = append(parts, since)
+
+	line := icon + " " + strings.Join(parts, " · ")
+	if alert.GeneratorURL != "" {
+		line += fmt.Sprintf(` <a href="%s">source</a>`, html.EscapeString(alert.GeneratorURL))
+	}
+	return line
+}
+
+// alertSummary returns the summary annotation, falling back to the description
+func alertSummary(annotations map[string]string) string {
+	if summary := annotations["summary"]; summary != "" {
+		return summary
+	}
+	return annotations["description"]
+}
+
+// sortedLabels returns labels as sorted "name=value" pairs without the skipped one
+func sortedLabels(labels map[string]string, skip string) []string {
+	result := make([]string, 0, len(labels))
+	for name, value := range labels {
+		if name != skip {
+			result = append(result, name+"="+value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// handleAlertmanagerCallback creates a silence matching the group labels
+func (b *Bot) handleAlertmanagerCallback(c tele.Context) error {
+	id, ok := strings.CutPrefix(c.Callback().Data, "\fam:silence:")
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	record, err := b.app.FindRecordById("alertmanager_groups", id)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Alert group not found"})
+	}
+
+	var labels map[string]string
+	if err := record.UnmarshalJSONField("labels", &labels); err != nil || len(labels) == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ The group has no labels to silence"})
+	}
+
+	now := time.Now()
+	sender := c.Sender()
+	_, err = b.alertmanager.CreateSilence(alertmanager.Silence{
+		Matchers:  alertmanager.EqualMatchers(labels),
+		StartsAt:  now,
+		EndsAt:    now.Add(alertmanagerSilence),
+		CreatedBy: strings.TrimSpace(sender.FirstName + " " + sender.LastName),
+		Comment:   "Silenced from Telegram",
+	})
+	if err != nil {
+		b.app.Logger().Error("Error creating silence", "error", err, "group", record.GetString("group_key"))
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to create the silence", ShowAlert: true})
+	}
+
+	record.Set("silenced_until", now.Add(alertmanagerSilence))
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving alert group", "error", err)
+	}
+
+	msg := c.Message()
+	text := msg.Text + fmt.Sprintf("\n\n🔕 Silenced until %s by %s", now.Add(alertmanagerSilence).Format("15:04"), sender.FirstName)
+	// The silence button is replaced by the note, the alert buttons stay
+	opts := &tele.SendOptions{Entities: msg.Entities, ReplyMarkup: filterKeyboard(msg.ReplyMarkup, "am:"), DisableWebPagePreview: true}
+	edited, err := b.bot.Edit(msg, text, opts)
+	if err != nil {
+		b.app.Logger().Error("Error editing Alertmanager message", "error", err)
+	} else {
+		b.saveEditedAlert(edited)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "🔕 Silenced for 1h"})
+}
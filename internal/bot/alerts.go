@@ -0,0 +1,440 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Severities and statuses of the alerts collection
+const (
+	alertInfo     = "info"
+	alertWarning  = "warning"
+	alertCritical = "critical"
+
+	alertOpen     = "open"
+	alertAcked    = "acked"
+	alertResolved = "resolved"
+)
+
+// Sources of the alerts collection
+const (
+	alertSourceWebhook      = "webhook"
+	alertSourceAlertmanager = "alertmanager"
+	alertSourceWatch        = "ha_watch"
+	alertSourceCheck        = "check"
+)
+
+// alertMuteDuration is the duration of the mute button
+const alertMuteDuration = time.Hour
+
+// errAlertMuted is returned for alerts that were muted with the same key
+var errAlertMuted = errors.New("alert is muted")
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// alert is a message posted with acknowledgement buttons, alerts with the
+// same source and key share mutes
+type alert struct {
+	source   string
+	key      string
+	severity string
+	title    string
+	loc      location
+	text     string
+	mode     tele.ParseMode
+	// photo is sent instead of the text, the text is its caption
+	photo *tele.Photo
+	// rows are source specific buttons shown above the alert buttons
+	rows []tele.Row
+}
+
+// alertContent is the posted alert message as stored in the content field,
+// buttons of escalation messages edit the alert message from it
+type alertContent struct {
+	Text     string                `json:"text"`
+	Entities tele.Entities         `json:"entities,omitempty"`
+	Photo    bool                  `json:"photo,omitempty"`
+	Keyboard [][]tele.InlineButton `json:"keyboard,omitempty"`
+}
+
+func messageContent(msg *tele.Message) alertContent {
+	content := alertContent{Text: msg.Text, Entities: msg.Entities}
+	if msg.Photo != nil {
+		content = alertContent{Text: msg.Caption, Entities: msg.CaptionEntities, Photo: true}
+	}
+	if msg.ReplyMarkup != nil {
+		content.Keyboard = msg.ReplyMarkup.InlineKeyboard
+	}
+	return content
+}
+
+// postAlert records the alert in the alerts collection and posts it with
+// Ack, Resolve and Mute buttons
+func (b *Bot) postAlert(a alert) (*tele.Message, *core.Record, error) {
+	if b.alertMuted(a.source, a.key) {
+		return nil, nil, errAlertMuted
+	}
+
+	collection, err := b.app.FindCollectionByNameOrId("alerts")
+	if err != nil {
+		return nil, nil, err
+	}
+	record := core.NewRecord(collection)
+	record.Set("source", a.source)
+	record.Set("key", a.key)
+	record.Set("title", truncate(a.title, 500))
+	record.Set("severity", normalizeSeverity(a.severity))
+	record.Set("status", alertOpen)
+	if err := b.app.Save(record); err != nil {
+		return nil, nil, err
+	}
+
+	keyboard := &tele.ReplyMarkup{}
+	keyboard.Inline(append(a.rows, alertRow(keyboard, record))...)
+	opts := a.loc.sendOptions(keyboard)
+	opts.ParseMode = a.mode
+	opts.DisableWebPagePreview = true
+
+	var what any = a.text
+	if a.photo != nil {
+		a.photo.Caption = truncate(a.text, captionLimit)
+		what = a.photo
+	}
+	msg, err := b.bot.Send(a.loc.recipient(), what, opts)
+	if err != nil {
+		// Alerts that were never posted can't be acknowledged
+		if deleteErr := b.app.Delete(record); deleteErr != nil {
+			b.app.Logger().Error("Error deleting alert", "error", deleteErr, "alert", record.Id)
+		}
+		return nil, nil, err
+	}
+
+	record.Set("chat_id", msg.Chat.ID)
+	record.Set("thread_id", a.loc.threadID)
+	record.Set("message_id", msg.ID)
+	record.Set("content", messageContent(msg))
+	if err := b.app.Save(record); err != nil {
+		return msg, nil, err
+	}
+	return msg, record, nil
+}
+
+// saveAlertContent stores the alert message after it was edited
+func (b *Bot) saveAlertContent(record *core.Record, msg *tele.Message) {
+	record.Set("content", messageContent(msg))
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving alert content", "error", err, "alert", record.Id)
+	}
+}
+
+// saveEditedAlert stores the content of a message edited by the buttons of
+// its source, messages that aren't alerts are ignored
+func (b *Bot) saveEditedAlert(msg *tele.Message) {
+	record, err := b.app.FindFirstRecordByFilter("alerts", "chat_id = {:chat} && message_id = {:message}", dbx.Params{
+		"chat":    msg.Chat.ID,
+		"message": msg.ID,
+	})
+	if err != nil {
+		return
+	}
+	b.saveAlertContent(record, msg)
+}
+
+// alertMuted reports whether an alert with the source and key is muted
+func (b *Bot) alertMuted(source, key string) bool {
+	if key == "" {
+		return false
+	}
+	_, err := b.app.FindFirstRecordByFilter("alerts", "source = {:source} && key = {:key} && muted_until > {:now}", dbx.Params{
+		"source": source,
+		"key":    key,
+		"now":    types.NowDateTime().String(),
+	})
+	return err == nil
+}
+
+// latestAlert returns the most recent alert with the source and key
+func (b *Bot) latestAlert(source, key string) (*core.Record, error) {
+	records, err := b.app.FindRecordsByFilter("alerts", "source = {:source} && key = {:key}", "-created", 1, 0, dbx.Params{"source": source, "key": key})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no alerts")
+	}
+	return records[0], nil
+}
+
+// resolveAlert marks the alert resolved on behalf of its source, the
+// buttons are removed from the alert message unless keepButtons is set
+func (b *Bot) resolveAlert(record *core.Record, by string, keepButtons bool) {
+	if record.GetString("status") == alertResolved {
+		return
+	}
+	record.Set("status", alertResolved)
+	record.Set("resolved_by", by)
+	record.Set("resolved_at", time.Now())
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error resolving alert", "error", err, "alert", record.Id)
+		return
+	}
+
+	if keepButtons || record.GetInt("message_id") == 0 {
+		return
+	}
+	if _, err := b.bot.EditReplyMarkup(alertMessage(record), nil); err != nil {
+		b.app.Logger().Debug("Error removing alert buttons", "error", err, "alert", record.Id)
+	}
+}
+
+// alertRow returns the buttons for the status of the alert, resolved
+// alerts have none
+func alertRow(keyboard *tele.ReplyMarkup, record *core.Record) tele.Row {
+	var buttons []tele.Btn
+	switch record.GetString("status") {
+	case alertResolved:
+		return nil
+	case alertOpen:
+		buttons = append(buttons, keyboard.Data("👀 Ack", "al:ack:"+record.Id))
+	}
+	return append(buttons,
+		keyboard.Data("✅ Resolve", "al:resolve:"+record.Id),
+		keyboard.Data("🔕 Mute 1h", "al:mute:"+record.Id),
+	)
+}
+
+// alertStatusLines describes who acknowledged, muted or resolved the
+// alert, for messages that are rendered again by their source
+func alertStatusLines(record *core.Record) []string {
+	var lines []string
+	if by := record.GetString("acked_by"); by != "" {
+		lines = append(lines, fmt.Sprintf("👀 Acked by %s at %s", by, record.GetDateTime("acked_at").Time().Local().Format("15:04")))
+	}
+	if until := record.GetDateTime("muted_until").Time(); until.After(time.Now()) {
+		lines = append(lines, fmt.Sprintf("🔕 Muted until %s", until.Local().Format("15:04")))
+	}
+	if by := record.GetString("resolved_by"); by != "" {
+		lines = append(lines, fmt.Sprintf("✅ Resolved by %s at %s", by, record.GetDateTime("resolved_at").Time().Local().Format("15:04")))
+	}
+	return lines
+}
+
+// handleAlertCallback handles the Ack, Resolve and Mute buttons of alerts
+// in the topic and in escalation messages
+func (b *Bot) handleAlertCallback(c tele.Context) error {
+	parts := strings.Split(strings.TrimPrefix(c.Callback().Data, "\fal:"), ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+	action, id := parts[0], parts[1]
+
+	record, err := b.app.FindRecordById("alerts", id)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Alert not found"})
+	}
+
+	name := c.Sender().FirstName
+	now := time.Now()
+	var note string
+	switch action {
+	case "ack":
+		if record.GetString("status") != alertOpen {
+			return c.Respond(&tele.CallbackResponse{Text: "Already " + record.GetString("status")})
+		}
+		record.Set("status", alertAcked)
+		record.Set("acked_by", name)
+		record.Set("acked_at", now)
+		note = fmt.Sprintf("👀 Acked by %s at %s", name, now.Format("15:04"))
+	case "resolve":
+		if record.GetString("status") == alertResolved {
+			return c.Respond(&tele.CallbackResponse{Text: "Already resolved"})
+		}
+		record.Set("status", alertResolved)
+		record.Set("resolved_by", name)
+		record.Set("resolved_at", now)
+		note = fmt.Sprintf("✅ Resolved by %s at %s", name, now.Format("15:04"))
+	case "mute":
+		// Muting means somebody has seen the alert, so it is not escalated
+		if record.GetString("status") == alertOpen {
+			record.Set("status", alertAcked)
+			record.Set("acked_by", name)
+			record.Set("acked_at", now)
+		}
+		record.Set("muted_until", now.Add(alertMuteDuration))
+		note = fmt.Sprintf("🔕 Muted until %s by %s", now.Add(alertMuteDuration).Format("15:04"), name)
+	default:
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving alert", "error", err, "alert", record.Id)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to update the alert"})
+	}
+
+	msg := c.Message()
+	var target tele.Editable = msg
+	content := messageContent(msg)
+
+	// Escalations are answered in private, the alert message is edited from
+	// its stored content
+	if msg.ID != record.GetInt("message_id") && record.GetInt("message_id") != 0 {
+		if _, err := b.appendAlertNote(msg, content, record, note); err != nil {
+			b.app.Logger().Error("Error editing escalation message", "error", err, "alert", record.Id)
+		}
+
+		original := alertMessage(record)
+		target = original
+		if err := record.UnmarshalJSONField("content", &content); err != nil || content.Text == "" {
+			// Alerts posted before their content was stored get a reply instead
+			opts := &tele.SendOptions{
+				ThreadID: record.GetInt("thread_id"),
+				ReplyTo:  &tele.Message{ID: record.GetInt("message_id"), Chat: &tele.Chat{ID: original.ChatID}},
+			}
+			if _, err := b.bot.Send(&tele.Chat{ID: original.ChatID}, note, opts); err != nil {
+				b.app.Logger().Error("Error replying to alert", "error", err, "alert", record.Id)
+			}
+			return c.Respond(&tele.CallbackResponse{Text: note})
+		}
+	}
+
+	edited, err := b.appendAlertNote(target, content, record, note)
+	if err != nil {
+		b.app.Logger().Error("Error editing alert message", "error", err, "alert", record.Id)
+	} else {
+		b.saveAlertContent(record, edited)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: note})
+}
+
+// appendAlertNote adds the note to the text or caption of the message and
+// updates the alert buttons, buttons of the source are kept
+func (b *Bot) appendAlertNote(msg tele.Editable, content alertContent, record *core.Record, note string) (*tele.Message, error) {
+	keyboard := filterKeyboard(&tele.ReplyMarkup{InlineKeyboard: content.Keyboard}, "al:")
+	if row := alertRow(keyboard, record); len(row) > 0 {
+		buttons := make([]tele.InlineButton, 0, len(row))
+		for _, button := range row {
+			buttons = append(buttons, *button.Inline())
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons)
+	}
+
+	if content.Photo {
+		return b.bot.EditCaption(msg, truncate(content.Text+"\n\n"+note, captionLimit), &tele.SendOptions{Entities: content.Entities, ReplyMarkup: keyboard})
+	}
+	return b.bot.Edit(msg, content.Text+"\n\n"+note, &tele.SendOptions{Entities: content.Entities, ReplyMarkup: keyboard, DisableWebPagePreview: true})
+}
+
+// startAlertEscalation sends critical alerts nobody acknowledged within
+// ALERT_ESCALATE_AFTER to the superuser in private
+func (b *Bot) startAlertEscalation() {
+	if b.alertEscalateAfter <= 0 {
+		return
+	}
+	b.app.Cron().MustAdd("alerts_escalation", "* * * * *", b.escalateAlerts)
+}
+
+func (b *Bot) escalateAlerts() {
+	before := types.NowDateTime().Add(-b.alertEscalateAfter)
+	records, err := b.app.FindRecordsByFilter("alerts",
+		"status = {:status} && severity = {:severity} && escalated_at = '' && message_id != 0 && created <= {:before}",
+		"created", 0, 0,
+		dbx.Params{"status": alertOpen, "severity": alertCritical, "before": before.String()})
+	if err != nil {
+		b.app.Logger().Error("Error loading alerts to escalate", "error", err)
+		return
+	}
+
+	for _, record := range records {
+		record.Set("escalated_at", time.Now())
+		if err := b.app.Save(record); err != nil {
+			b.app.Logger().Error("Error saving alert", "error", err, "alert", record.Id)
+			continue
+		}
+
+		text := fmt.Sprintf("🚨 <b>Critical alert not acknowledged for %s</b>\n\n%s",
+			formatDuration(time.Since(record.GetDateTime("created").Time())), html.EscapeString(record.GetString("title")))
+		keyboard := &tele.ReplyMarkup{}
+		var rows []tele.Row
+		if link := alertLink(record); link != "" {
+			rows = append(rows, keyboard.Row(keyboard.URL("↗️ Open", link)))
+		}
+		keyboard.Inline(append(rows, alertRow(keyboard, record))...)
+
+		if _, err := b.bot.Send(&tele.User{ID: b.superuserID}, text, keyboard, tele.ModeHTML); err != nil {
+			b.app.Logger().Error("Error escalating alert", "error", err, "alert", record.Id)
+		}
+	}
+}
+
+func alertMessage(record *core.Record) tele.StoredMessage {
+	return tele.StoredMessage{
+		MessageID: strconv.Itoa(record.GetInt("message_id")),
+		ChatID:    int64(record.GetInt("chat_id")),
+	}
+}
+
+// alertLink returns the t.me link of an alert posted in a supergroup
+func alertLink(record *core.Record) string {
+	chatID := strconv.Itoa(record.GetInt("chat_id"))
+	internalID, ok := strings.CutPrefix(chatID, "-100")
+	if !ok {
+		return ""
+	}
+	if threadID := record.GetInt("thread_id"); threadID != 0 {
+		return fmt.Sprintf("https://t.me/c/%s/%d/%d", internalID, threadID, record.GetInt("message_id"))
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", internalID, record.GetInt("message_id"))
+}
+
+// filterKeyboard copies the inline keyboard without buttons whose callback
+// data starts with the prefix
+func filterKeyboard(markup *tele.ReplyMarkup, prefix string) *tele.ReplyMarkup {
+	keyboard := &tele.ReplyMarkup{}
+	if markup == nil {
+		return keyboard
+	}
+	for _, row := range markup.InlineKeyboard {
+		var kept []tele.InlineButton
+		for _, button := range row {
+			if !strings.HasPrefix(button.Data, "\f"+prefix) {
+				kept = append(kept, button)
+			}
+		}
+		if len(kept) > 0 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, kept)
+		}
+	}
+	return keyboard
+}
+
+// normalizeSeverity maps common severity labels to the alert severities
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "page", "error", "high":
+		return alertCritical
+	case "info", "none", "low":
+		return alertInfo
+	default:
+		return alertWarning
+	}
+}
+
+// plainTitle returns the first line of a message without markup
+func plainTitle(text string, mode tele.ParseMode) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if mode == tele.ModeHTML {
+		title = html.UnescapeString(htmlTagRe.ReplaceAllString(title, ""))
+	}
+	return title
+}
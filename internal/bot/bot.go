@@ -2,8 +2,11 @@ package bot
 
 import (
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/biozz/biozz-dev-bot/internal/alertmanager"
+	"github.com/biozz/biozz-dev-bot/internal/fetcher"
 	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
 	"github.com/biozz/biozz-dev-bot/internal/librechat"
 	"github.com/pocketbase/pocketbase"
@@ -16,18 +19,65 @@ type Bot struct {
 	bot              *tele.Bot
 	librechatClient  *librechat.LibreChat
 	haClient         *ha.HomeAssistant
+	haWS             *ha.WebSocket
+	alertmanager     *alertmanager.Alertmanager
+	fetcher          fetcher.Fetcher
 	superuserID      int64
 	supergroupID     int64
 	gptThreadID      int64
 	openAIAPIKey     string
 	openRouterAPIKey string
 	summaryModel     string
+	convoModel       string
+	inlineLog        bool
+	haSyncDomains    []string
+	haSyncCron       string
+	haNotifyToken    string
+
+	// Home Assistant health monitor
+	haHealthCron       string
+	haBatteryThreshold float64
+	haUnavailableAfter time.Duration
+	healthMu           sync.Mutex
+
+	// alertmanagerMu serializes updates of Alertmanager groups
+	alertmanagerToken string
+	alertmanagerMu    sync.Mutex
+
+	// alertEscalateAfter is how long critical alerts wait for an Ack before
+	// they are sent to the superuser
+	alertEscalateAfter time.Duration
+
+	// checksMu keeps check runs from overlapping
+	checksMu sync.Mutex
+
+	// pushBursts are recent push messages by repository and branch
+	pushMu     sync.Mutex
+	pushBursts map[string]*pushBurst
+
+	inlineMu  sync.Mutex
+	inlineSeq map[int64]uint64
+
+	// watchMu serializes watch evaluation, watchedMu guards the entity cache
+	watchMu         sync.Mutex
+	watchedMu       sync.RWMutex
+	watchedEntities map[string]bool
+
+	// scheduleMu keeps cancellations from racing with running schedules
+	scheduleMu sync.Mutex
+
+	// confirmations are actions on sensitive devices waiting for a second tap
+	confirmMu     sync.Mutex
+	confirmations map[string]*pendingConfirmation
 }
 
 type NewBotParams struct {
 	App                 *pocketbase.PocketBase
 	LibreChatClient     *librechat.LibreChat
 	HomeAssistantClient *ha.HomeAssistant
+	HomeAssistantWS     *ha.WebSocket
+	Alertmanager        *alertmanager.Alertmanager
+	Fetcher             fetcher.Fetcher
 	BotToken            string
 	SuperGroupID        int64
 	SuperUserID         int64
@@ -36,6 +86,25 @@ type NewBotParams struct {
 	OpenAIAPIKey     string
 	OpenRouterAPIKey string
 	SummaryModel     string
+	ConvoModel       string
+	// InlineLog stores inline mode questions in a LibreChat conversation
+	InlineLog bool
+	// HomeAssistantSyncDomains is a comma separated list of domains imported
+	// by /ha sync, HomeAssistantSyncCron enables the periodic sync
+	HomeAssistantSyncDomains string
+	HomeAssistantSyncCron    string
+	// HomeAssistantNotifyToken enables POST /api/ha/notify for automations
+	HomeAssistantNotifyToken string
+	// HomeAssistantHealthCron enables the periodic health report of low
+	// batteries and entities unavailable for HomeAssistantUnavailableAfter
+	HomeAssistantHealthCron       string
+	HomeAssistantBatteryThreshold float64
+	HomeAssistantUnavailableAfter time.Duration
+	// AlertmanagerToken enables POST /api/alertmanager
+	AlertmanagerToken string
+	// AlertEscalateAfter is how long critical alerts wait for an Ack, a
+	// negative duration disables the escalation
+	AlertEscalateAfter time.Duration
 }
 
 func New(params NewBotParams) (*Bot, error) {
@@ -46,6 +115,7 @@ func New(params NewBotParams) (*Bot, error) {
 			AllowedUpdates: []string{
 				"message",
 				"callback_query",
+				"inline_query",
 			},
 		},
 	}
@@ -59,15 +129,42 @@ func New(params NewBotParams) (*Bot, error) {
 		bot:             b,
 		librechatClient: params.LibreChatClient,
 		haClient:        params.HomeAssistantClient,
+		haWS:            params.HomeAssistantWS,
+		alertmanager:    params.Alertmanager,
+		fetcher:         params.Fetcher,
 		app:             params.App,
 		superuserID:     params.SuperUserID,
 		supergroupID:    params.SuperGroupID,
 		gptThreadID:     params.GPTThreadID,
 		// API Keys
-		openAIAPIKey:     params.OpenAIAPIKey,
-		openRouterAPIKey: params.OpenRouterAPIKey,
-		summaryModel:     params.SummaryModel,
+		openAIAPIKey:      params.OpenAIAPIKey,
+		openRouterAPIKey:  params.OpenRouterAPIKey,
+		summaryModel:      params.SummaryModel,
+		convoModel:        params.ConvoModel,
+		inlineLog:         params.InlineLog,
+		haSyncDomains:     parseSyncDomains(params.HomeAssistantSyncDomains),
+		haSyncCron:        params.HomeAssistantSyncCron,
+		haNotifyToken:     params.HomeAssistantNotifyToken,
+		haHealthCron:      params.HomeAssistantHealthCron,
+		alertmanagerToken: params.AlertmanagerToken,
+		inlineSeq:         make(map[int64]uint64),
+		confirmations:     make(map[string]*pendingConfirmation),
+		pushBursts:        make(map[string]*pushBurst),
 	}
+
+	bot.haBatteryThreshold = params.HomeAssistantBatteryThreshold
+	if bot.haBatteryThreshold == 0 {
+		bot.haBatteryThreshold = defaultBatteryThreshold
+	}
+	bot.haUnavailableAfter = params.HomeAssistantUnavailableAfter
+	if bot.haUnavailableAfter == 0 {
+		bot.haUnavailableAfter = time.Hour
+	}
+	bot.alertEscalateAfter = params.AlertEscalateAfter
+	if bot.alertEscalateAfter == 0 {
+		bot.alertEscalateAfter = 15 * time.Minute
+	}
+
 	return bot, nil
 }
 
@@ -80,9 +177,46 @@ func (b *Bot) Start() {
 	// Main commands
 	b.bot.Handle("/gpt", b.newGPTChat)
 	b.bot.Handle("/ha", b.handleHomeAssistant)
+	b.bot.Handle("/scene", b.handleScenes)
+	b.bot.Handle("/script", b.handleScripts)
+	b.bot.Handle("/automations", b.handleAutomations)
+	b.bot.Handle("/graph", b.handleGraph)
+	b.bot.Handle("/hastate", b.handleHAState)
+	b.bot.Handle("/hatpl", b.handleHATemplate)
+	b.bot.Handle("/cam", b.handleCameras)
+	b.bot.Handle("/in", b.handleIn)
+	b.bot.Handle("/at", b.handleAt)
+	b.bot.Handle("/every", b.handleEvery)
+	b.bot.Handle("/schedules", b.handleSchedules)
+	b.bot.Handle("/digest", b.handleDigest)
+	b.bot.Handle("/health", b.handleHealth)
+	b.bot.Handle("/webhooks", b.handleWebhooks)
+	b.bot.Handle("/status", b.handleStatus)
+
+	// Quick actions on replied-to messages
+	b.bot.Handle("/tldr", b.handleQuickAction("tldr"))
+	b.bot.Handle("/translate", b.handleQuickAction("translate"))
+	b.bot.Handle("/explain", b.handleQuickAction("explain"))
+	b.bot.Handle("/fix", b.handleQuickAction("fix"))
+	b.bot.Handle("/read", b.handleRead)
+	b.bot.Handle("/run", b.handleRunTemplate)
 
 	b.bot.Handle(tele.OnCallback, b.handleCallback)
 	b.bot.Handle(tele.OnText, b.handleText)
+	b.bot.Handle(tele.OnQuery, b.handleInlineQuery)
+
+	// Device PINs are hashed whenever a device is saved
+	b.hashDevicePINs()
+
+	// Background jobs
+	b.startWatcher()
+	b.startDeviceSync()
+	b.startScheduler()
+	b.startDigests()
+	b.startHealthMonitor()
+	b.startWebhookCleanup()
+	b.startAlertEscalation()
+	b.startChecks()
 
 	b.bot.Start()
 }
@@ -97,6 +231,41 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		return b.handleHomeAssistantCallback(c)
 	}
 
+	// Handle scene, script and automation callbacks
+	if strings.HasPrefix(data, "hs:") {
+		return b.handleScriptsCallback(c)
+	}
+
+	// Handle schedule list callbacks
+	if strings.HasPrefix(data, "sc:") {
+		return b.handleScheduleCallback(c)
+	}
+
+	// Handle Home Assistant watch alert callbacks
+	if strings.HasPrefix(data, "hw:") {
+		return b.handleWatchCallback(c)
+	}
+
+	// Handle mute buttons of health reports
+	if strings.HasPrefix(data, "hh:") {
+		return b.handleHealthCallback(c)
+	}
+
+	// Handle Ack, Resolve and Mute buttons of alerts
+	if strings.HasPrefix(data, "al:") {
+		return b.handleAlertCallback(c)
+	}
+
+	// Handle silence buttons of Alertmanager groups
+	if strings.HasPrefix(data, "am:") {
+		return b.handleAlertmanagerCallback(c)
+	}
+
+	// Handle buttons of notifications sent by Home Assistant
+	if strings.HasPrefix(data, "hn:") {
+		return b.handleNotifyCallback(c)
+	}
+
 	return nil
 }
 
@@ -108,8 +277,12 @@ func (b *Bot) handleText(c tele.Context) error {
 	}
 	b.app.Logger().Debug("Received text", "text", c.Text(), "state", state)
 
-	if c.Message().ThreadID == int(b.gptThreadID) {
-		b.app.Logger().Debug("Received text", "text", c.Text(), "state", state)
+	// A running script dialog takes the text as a variable value
+	if handled, err := b.handleScriptDialog(c); handled || err != nil {
+		return err
+	}
+
+	if b.isGPTMessage(c) {
 		return b.handleGPTMessage(c)
 	}
 
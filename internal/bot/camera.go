@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// handleCameras sends a snapshot of the camera given as an argument or
+// lists the cameras to pick from
+func (b *Bot) handleCameras(c tele.Context) error {
+	entityID := strings.TrimSpace(c.Message().Payload)
+	if entityID == "" {
+		return b.sendEntityList(c, hsActionCameras)
+	}
+	if !strings.HasPrefix(entityID, "camera.") {
+		entityID = "camera." + entityID
+	}
+
+	c.Notify(tele.UploadingPhoto)
+
+	photo, err := b.cameraSnapshot(entityID, "")
+	if err != nil {
+		b.app.Logger().Error("Error getting camera snapshot", "error", err, "entity_id", entityID)
+		return c.Reply(fmt.Sprintf("❌ Error getting snapshot of %s", entityID))
+	}
+	return c.Send(photo)
+}
+
+// handleCameraButton sends the snapshot as a new message, so the keyboard
+// stays in place
+func (b *Bot) handleCameraButton(c tele.Context, entityID string) error {
+	photo, err := b.cameraSnapshot(entityID, "")
+	if err != nil {
+		b.app.Logger().Error("Error getting camera snapshot", "error", err, "entity_id", entityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error getting snapshot"})
+	}
+	if err := c.Send(photo); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// cameraSnapshot fetches the current image of the camera, the caption
+// defaults to the camera name and the time of the snapshot
+func (b *Bot) cameraSnapshot(entityID, caption string) (*tele.Photo, error) {
+	image, err := b.haClient.GetCameraSnapshot(entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if caption == "" {
+		name := entityID
+		if state, err := b.haClient.GetState(entityID); err == nil {
+			name = friendlyName(*state)
+		}
+		caption = fmt.Sprintf("📷 %s · %s", name, time.Now().Format("15:04:05"))
+	}
+
+	return &tele.Photo{
+		File:    tele.FromReader(bytes.NewReader(image)),
+		Caption: truncate(caption, captionLimit),
+	}, nil
+}
@@ -0,0 +1,420 @@
+package bot
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Types and statuses of the checks collection
+const (
+	checkHTTP = "http"
+	checkTCP  = "tcp"
+	checkTLS  = "tls"
+
+	checkUp   = "up"
+	checkDown = "down"
+)
+
+const (
+	// checkConcurrency caps the number of checks running at the same time
+	checkConcurrency = 5
+	// checkBodyLimit caps the response body searched for body_contains
+	checkBodyLimit = 1 << 20
+	// checkRetention is how long check results are kept
+	checkRetention = 30 * 24 * time.Hour
+
+	defaultCheckTimeout   = 10 * time.Second
+	defaultCheckThreshold = 2
+	defaultTLSDays        = 14
+)
+
+// checkClient doesn't follow redirects, a redirect to a login or error
+// page would otherwise pass as the 2xx of the target
+var checkClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// startChecks runs due checks every minute and removes old results once a day
+func (b *Bot) startChecks() {
+	b.app.Cron().MustAdd("checks", "* * * * *", b.runChecks)
+	b.app.Cron().MustAdd("check_results", "40 3 * * *", func() {
+		before := types.NowDateTime().Add(-checkRetention)
+		_, err := b.app.DB().Delete("check_results", dbx.NewExp("created < {:before}", dbx.Params{"before": before.String()})).Execute()
+		if err != nil {
+			b.app.Logger().Error("Error deleting old check results", "error", err)
+		}
+	})
+}
+
+// runChecks runs the checks whose interval has passed, a run still going
+// from the previous minute skips this one
+func (b *Bot) runChecks() {
+	if !b.checksMu.TryLock() {
+		return
+	}
+	defer b.checksMu.Unlock()
+
+	records, err := b.app.FindRecordsByFilter("checks", "enabled = true", "name", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading checks", "error", err)
+		return
+	}
+
+	now := time.Now()
+	sem := make(chan struct{}, checkConcurrency)
+	var wg sync.WaitGroup
+	for _, record := range records {
+		if !checkDue(record, now) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			b.runCheck(record)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkDue reports whether interval_minutes passed since the last run,
+// with a few seconds of slack for the cron tick
+func checkDue(record *core.Record, now time.Time) bool {
+	last := record.GetDateTime("last_checked").Time()
+	if last.IsZero() {
+		return true
+	}
+	interval := time.Duration(max(record.GetInt("interval_minutes"), 1)) * time.Minute
+	return now.Sub(last) >= interval-5*time.Second
+}
+
+// runCheck probes the target, stores the result and updates the state
+func (b *Bot) runCheck(record *core.Record) {
+	timeout := time.Duration(record.GetInt("timeout_seconds")) * time.Second
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := probeCheck(ctx, record)
+	latency := time.Since(start)
+
+	collection, collErr := b.app.FindCollectionByNameOrId("check_results")
+	if collErr != nil {
+		b.app.Logger().Error("Error finding check results", "error", collErr)
+		return
+	}
+	result := core.NewRecord(collection)
+	result.Set("check_id", record.Id)
+	result.Set("ok", err == nil)
+	result.Set("latency_ms", latency.Milliseconds())
+	if err != nil {
+		result.Set("error", truncate(err.Error(), 1000))
+	}
+	if err := b.app.Save(result); err != nil {
+		b.app.Logger().Error("Error saving check result", "error", err, "check", record.Id)
+	}
+
+	b.updateCheckState(record, err)
+}
+
+func probeCheck(ctx context.Context, record *core.Record) error {
+	target := record.GetString("target")
+	switch record.GetString("type") {
+	case checkHTTP:
+		return probeHTTP(ctx, target, record.GetInt("expected_status"), record.GetString("body_contains"))
+	case checkTCP:
+		return probeTCP(ctx, target)
+	case checkTLS:
+		days := record.GetInt("tls_days")
+		if days <= 0 {
+			days = defaultTLSDays
+		}
+		return probeTLS(ctx, target, days)
+	default:
+		return fmt.Errorf("unknown check type %q", record.GetString("type"))
+	}
+}
+
+// probeHTTP requests the URL and checks the status, any 2xx status without
+// an expected one, and that the body contains the substring
+func probeHTTP(ctx context.Context, target string, expectedStatus int, bodyContains string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := checkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if expectedStatus != 0 && resp.StatusCode != expectedStatus {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, expectedStatus)
+	}
+	if expectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	if bodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, checkBodyLimit))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), bodyContains) {
+			return fmt.Errorf("body does not contain %q", bodyContains)
+		}
+	}
+	return nil
+}
+
+// probeTCP connects to host:port
+func probeTCP(ctx context.Context, target string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeTLS verifies the certificate of host, host:port or a URL and fails
+// when it expires within the given days
+func probeTLS(ctx context.Context, target string, days int) error {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+
+	dialer := tls.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("no certificate")
+	}
+	left := time.Until(certs[0].NotAfter)
+	if left < time.Duration(days)*24*time.Hour {
+		return fmt.Errorf("certificate expires in %s", formatDuration(left))
+	}
+	return nil
+}
+
+// updateCheckState saves the result of a run and posts the status change
+func (b *Bot) updateCheckState(record *core.Record, probeErr error) {
+	flipped, since := applyCheckResult(record, probeErr, time.Now())
+
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving check", "error", err, "check", record.Id)
+		return
+	}
+	if !flipped {
+		return
+	}
+
+	if err := b.postCheckChange(record, since); err != nil {
+		b.app.Logger().Error("Error posting check status", "error", err, "check", record.Id)
+	}
+}
+
+// applyCheckResult flips the status only after failures_before_alert
+// failures or successes_before_recovery successes in a row, so a flapping
+// service doesn't alert on every run. since is when the previous status
+// began for flipped checks.
+func applyCheckResult(record *core.Record, probeErr error, now time.Time) (flipped bool, since time.Time) {
+	record.Set("last_checked", now)
+	record.Set("last_error", "")
+	if probeErr != nil {
+		record.Set("last_error", truncate(probeErr.Error(), 1000))
+	}
+
+	down := record.GetString("status") == checkDown
+	switch {
+	case (probeErr != nil) == down:
+		record.Set("streak", 0)
+		if record.GetString("status") == "" {
+			record.Set("status", checkUp)
+			record.Set("changed", now)
+		}
+	default:
+		threshold := record.GetInt("failures_before_alert")
+		if down {
+			threshold = record.GetInt("successes_before_recovery")
+		}
+		if threshold <= 0 {
+			threshold = defaultCheckThreshold
+		}
+		streak := record.GetInt("streak") + 1
+		record.Set("streak", streak)
+		flipped = streak >= threshold
+	}
+
+	if flipped {
+		since = record.GetDateTime("changed").Time()
+		record.Set("streak", 0)
+		record.Set("changed", now)
+		if down {
+			record.Set("status", checkUp)
+		} else {
+			record.Set("status", checkDown)
+		}
+	}
+	return flipped, since
+}
+
+// postCheckChange posts an alert when the check goes down and resolves it
+// with a recovery message when it is up again
+func (b *Bot) postCheckChange(record *core.Record, since time.Time) error {
+	name := record.GetString("name")
+	loc := b.alertsLocation(record.GetInt("thread_id"))
+
+	if record.GetString("status") == checkUp {
+		if alert, err := b.latestAlert(alertSourceCheck, record.Id); err == nil {
+			b.resolveAlert(alert, "recovery", false)
+		}
+		text := fmt.Sprintf("🟢 <b>%s</b> is up again", html.EscapeString(name))
+		if !since.IsZero() {
+			text += " after " + formatDuration(time.Since(since))
+		}
+		opts := loc.sendOptions(nil)
+		opts.ParseMode = tele.ModeHTML
+		_, err := b.bot.Send(loc.recipient(), text, opts)
+		return err
+	}
+
+	text := fmt.Sprintf("🔴 <b>%s</b> is down\n<code>%s</code>\n\n%s",
+		html.EscapeString(name), html.EscapeString(record.GetString("target")), html.EscapeString(record.GetString("last_error")))
+	_, _, err := b.postAlert(alert{
+		source:   alertSourceCheck,
+		key:      record.Id,
+		severity: record.GetString("severity"),
+		title:    name + " is down",
+		loc:      loc,
+		text:     text,
+		mode:     tele.ModeHTML,
+	})
+	if errors.Is(err, errAlertMuted) {
+		return nil
+	}
+	return err
+}
+
+// handleStatus lists the checks with their state and uptime over the last
+// day, week and month
+func (b *Bot) handleStatus(c tele.Context) error {
+	records, err := b.app.FindRecordsByFilter("checks", "", "name", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading checks", "error", err)
+		return c.Reply("❌ Error loading checks")
+	}
+	if len(records) == 0 {
+		return c.Reply("📭 No checks, add them to the checks collection")
+	}
+
+	lines := []string{"📶 Status"}
+	for _, record := range records {
+		lines = append(lines, b.formatCheckStatus(record))
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+func (b *Bot) formatCheckStatus(record *core.Record) string {
+	name := record.GetString("name")
+	if !record.GetBool("enabled") {
+		return "⏸ " + name + " (disabled)"
+	}
+
+	var parts []string
+	switch record.GetString("status") {
+	case checkUp:
+		parts = append(parts, "🟢 "+name)
+	case checkDown:
+		parts = append(parts, "🔴 "+name, "down for "+formatDuration(time.Since(record.GetDateTime("changed").Time())))
+	default:
+		return "⚪ " + name + " · not checked yet"
+	}
+
+	var latency int64
+	for _, period := range []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, checkRetention} {
+		uptime, avg, err := b.checkUptime(record.Id, period)
+		if err != nil {
+			b.app.Logger().Error("Error computing uptime", "error", err, "check", record.Id)
+			break
+		}
+		if uptime < 0 {
+			break
+		}
+		if latency == 0 {
+			latency = avg
+		}
+		parts = append(parts, formatPercent(uptime)+" "+formatPeriod(period))
+	}
+	if latency > 0 {
+		parts = append(parts, fmt.Sprintf("%d ms", latency))
+	}
+
+	if record.GetString("status") == checkDown && record.GetString("last_error") != "" {
+		parts = append(parts, truncate(record.GetString("last_error"), 100))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// checkUptime returns the percentage of successful results over the period
+// and their average latency in milliseconds, -1 without results
+func (b *Bot) checkUptime(checkID string, period time.Duration) (float64, int64, error) {
+	var row struct {
+		Total   int     `db:"total"`
+		Up      int     `db:"up"`
+		Latency float64 `db:"latency"`
+	}
+	err := b.app.DB().
+		Select("COUNT(*) AS total", "COALESCE(SUM(ok), 0) AS up", "COALESCE(AVG(CASE WHEN ok THEN latency_ms END), 0) AS latency").
+		From("check_results").
+		Where(dbx.NewExp("check_id = {:check} AND created >= {:since}", dbx.Params{
+			"check": checkID,
+			"since": types.NowDateTime().Add(-period).String(),
+		})).
+		One(&row)
+	if err != nil {
+		return 0, 0, err
+	}
+	if row.Total == 0 {
+		return -1, 0, nil
+	}
+	return float64(row.Up) / float64(row.Total) * 100, int64(math.Round(row.Latency)), nil
+}
+
+// formatPercent rounds down so a single failure never shows as 100%
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(math.Floor(p*100)/100, 'f', -1, 64) + "%"
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("service is healthy"))
+		case "/missing":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		bodyContains   string
+		wantErr        string
+	}{
+		{"2xx", "/ok", 0, "", ""},
+		{"expected status", "/missing", http.StatusNotFound, "", ""},
+		{"unexpected status", "/missing", 0, "", "status 404"},
+		{"status mismatch", "/ok", http.StatusCreated, "", "status 200, expected 201"},
+		{"body contains", "/ok", 0, "healthy", ""},
+		{"body missing", "/ok", 0, "degraded", `body does not contain "degraded"`},
+		{"redirect not followed", "/redirect", 0, "", "status 302"},
+		{"expected redirect", "/redirect", http.StatusFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probeHTTP(context.Background(), srv.URL+tt.path, tt.expectedStatus, tt.bodyContains)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("probeHTTP() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("probeHTTP() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	if err := probeTCP(context.Background(), addr); err != nil {
+		t.Fatalf("probeTCP() error = %v", err)
+	}
+
+	ln.Close()
+	if err := probeTCP(context.Background(), addr); err == nil {
+		t.Fatal("probeTCP() of a closed port succeeded")
+	}
+}
+
+func newCheckRecord(failures, successes int) *core.Record {
+	collection := core.NewBaseCollection("checks")
+	collection.Fields.Add(
+		&core.TextField{Name: "status"},
+		&core.NumberField{Name: "streak"},
+		&core.NumberField{Name: "failures_before_alert"},
+		&core.NumberField{Name: "successes_before_recovery"},
+		&core.DateField{Name: "changed"},
+		&core.DateField{Name: "last_checked"},
+		&core.TextField{Name: "last_error"},
+	)
+	record := core.NewRecord(collection)
+	record.Set("failures_before_alert", failures)
+	record.Set("successes_before_recovery", successes)
+	return record
+}
+
+func TestApplyCheckResultFlapping(t *testing.T) {
+	failed := errors.New("connection refused")
+	record := newCheckRecord(3, 2)
+	start := time.Now()
+
+	// The first run sets the status without a change to post
+	if flipped, _ := applyCheckResult(record, nil, start); flipped || record.GetString("status") != checkUp {
+		t.Fatalf("first run: flipped %v, status %q", flipped, record.GetString("status"))
+	}
+
+	// Failures interrupted by a success never reach the threshold
+	results := []error{failed, failed, nil, failed, failed, nil}
+	for i, result := range results {
+		if flipped, _ := applyCheckResult(record, result, start.Add(time.Duration(i+1)*time.Minute)); flipped {
+			t.Fatalf("run %d flipped a flapping check", i)
+		}
+	}
+	if record.GetString("status") != checkUp {
+		t.Fatalf("status = %q, want %q", record.GetString("status"), checkUp)
+	}
+
+	var flipped bool
+	for i := range 3 {
+		flipped, _ = applyCheckResult(record, failed, start.Add(time.Duration(10+i)*time.Minute))
+		if flipped != (i == 2) {
+			t.Fatalf("failure %d: flipped %v", i+1, flipped)
+		}
+	}
+	if record.GetString("status") != checkDown || record.GetString("last_error") != failed.Error() {
+		t.Fatalf("status = %q, last_error = %q", record.GetString("status"), record.GetString("last_error"))
+	}
+
+	downAt := start.Add(12 * time.Minute)
+	if flipped, _ := applyCheckResult(record, nil, start.Add(13*time.Minute)); flipped {
+		t.Fatal("first success recovered the check")
+	}
+	flipped, since := applyCheckResult(record, nil, start.Add(14*time.Minute))
+	if !flipped || record.GetString("status") != checkUp {
+		t.Fatalf("second success: flipped %v, status %q", flipped, record.GetString("status"))
+	}
+	if !since.Equal(downAt) {
+		t.Fatalf("since = %v, want %v", since, downAt)
+	}
+}
+
+func TestApplyCheckResultDefaultThreshold(t *testing.T) {
+	record := newCheckRecord(0, 0)
+	applyCheckResult(record, nil, time.Now())
+
+	for i := range defaultCheckThreshold {
+		flipped, _ := applyCheckResult(record, errors.New("timeout"), time.Now())
+		if flipped != (i == defaultCheckThreshold-1) {
+			t.Fatalf("failure %d: flipped %v", i+1, flipped)
+		}
+	}
+}
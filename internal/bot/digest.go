@@ -0,0 +1,406 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Sections of the digests collection
+const (
+	digestPresence    = "presence"
+	digestEnergy      = "energy"
+	digestTemperature = "temperature"
+	digestDevices     = "devices"
+	digestBatteries   = "batteries"
+)
+
+const (
+	defaultBatteryThreshold = 20
+	// digestSectionLimit caps the number of lines of a single section
+	digestSectionLimit = 15
+)
+
+// startDigests posts enabled digests when their cron expression is due
+func (b *Bot) startDigests() {
+	b.app.Cron().MustAdd("digests", "* * * * *", b.runDigests)
+}
+
+func (b *Bot) runDigests() {
+	records, err := b.app.FindRecordsByFilter("digests", "enabled = true && cron != ''", "", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading digests", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		if !cronDue(record.GetString("cron"), record.GetDateTime("last_run").Time(), now) {
+			continue
+		}
+
+		record.Set("last_run", now)
+		if err := b.app.Save(record); err != nil {
+			b.app.Logger().Error("Error saving digest", "error", err, "digest", record.Id)
+			continue
+		}
+
+		text := b.buildDigest(record, now)
+		loc := b.alertsLocation(record.GetInt("thread_id"))
+		opts := loc.sendOptions(nil)
+		opts.ParseMode = tele.ModeHTML
+		if _, err := b.bot.Send(loc.recipient(), text, opts); err != nil {
+			b.app.Logger().Error("Error sending digest", "error", err, "digest", record.Id)
+		}
+	}
+}
+
+// handleDigest builds a digest on demand, the name defaults to the first
+// digest in the collection
+func (b *Bot) handleDigest(c tele.Context) error {
+	name := strings.TrimSpace(c.Message().Payload)
+
+	var record *core.Record
+	var err error
+	if name != "" {
+		record, err = b.app.FindFirstRecordByFilter("digests", "name = {:name}", dbx.Params{"name": name})
+	} else {
+		var records []*core.Record
+		records, err = b.app.FindRecordsByFilter("digests", "", "created", 1, 0)
+		if err == nil && len(records) > 0 {
+			record = records[0]
+		}
+	}
+	if err != nil || record == nil {
+		return c.Reply("📭 No digest found, digests are configured in the digests collection")
+	}
+
+	c.Notify(tele.Typing)
+	return c.Reply(b.buildDigest(record, time.Now()), tele.ModeHTML)
+}
+
+// buildDigest renders the sections of the digest, a failing section is
+// reported in place so the others are still posted
+func (b *Bot) buildDigest(record *core.Record, now time.Time) string {
+	lines := []string{fmt.Sprintf("📋 <b>%s</b> · %s", html.EscapeString(humanizeKey(record.GetString("name"))), now.Format("Mon, Jan 2"))}
+
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		b.app.Logger().Error("Error getting states for digest", "error", err)
+		return strings.Join(append(lines, "", "❌ Error getting states from Home Assistant"), "\n")
+	}
+
+	for _, section := range record.GetStringSlice("sections") {
+		var title string
+		var body []string
+		var err error
+		switch section {
+		case digestPresence:
+			title, body = "🏠 Presence", presenceSection(states)
+		case digestEnergy:
+			title = "⚡ Energy yesterday"
+			body, err = b.energySection(states, splitList(record.GetString("energy_entities")), now)
+		case digestTemperature:
+			title = "🌡 Outdoor temperature, 24h"
+			body, err = b.temperatureSection(record.GetString("temperature_entity"), now)
+		case digestDevices:
+			title = "💡 Left on"
+			body, err = b.devicesSection(states, now)
+		case digestBatteries:
+			threshold := record.GetFloat("battery_threshold")
+			if threshold == 0 {
+				threshold = defaultBatteryThreshold
+			}
+			title, body = "🔋 Low battery", batteriesSection(states, threshold)
+		default:
+			continue
+		}
+		if err != nil {
+			b.app.Logger().Error("Error building digest section", "error", err, "section", section)
+			body = []string{"❌ " + html.EscapeString(err.Error())}
+		}
+
+		lines = append(lines, "", "<b>"+title+"</b>")
+		lines = append(lines, limitLines(body, digestSectionLimit)...)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// presenceSection lists people at home and away with their zone
+func presenceSection(states []ha.StateResponse) []string {
+	var home, away []string
+	for _, state := range states {
+		if entityDomain(state.EntityID) != "person" {
+			continue
+		}
+		name := html.EscapeString(friendlyName(state))
+		switch state.State {
+		case "home":
+			home = append(home, name)
+		case "not_home":
+			away = append(away, name)
+		default:
+			away = append(away, fmt.Sprintf("%s (%s)", name, html.EscapeString(state.State)))
+		}
+	}
+	if len(home) == 0 && len(away) == 0 {
+		return []string{"No person entities"}
+	}
+
+	var lines []string
+	if len(home) > 0 {
+		lines = append(lines, "Home: "+strings.Join(home, ", "))
+	}
+	if len(away) > 0 {
+		lines = append(lines, "Away: "+strings.Join(away, ", "))
+	}
+	return lines
+}
+
+// energySection sums up increases of energy meters over the previous day,
+// meters resetting to zero are handled. Without configured entities all
+// sensors with the energy device class are used.
+func (b *Bot) energySection(states []ha.StateResponse, entityIDs []string, now time.Time) ([]string, error) {
+	byID := make(map[string]ha.StateResponse)
+	for _, state := range states {
+		byID[state.EntityID] = state
+		if len(entityIDs) == 0 && isEnergyMeter(state) {
+			entityIDs = append(entityIDs, state.EntityID)
+		}
+	}
+	if len(entityIDs) == 0 {
+		return []string{"No energy sensors"}, nil
+	}
+
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := end.AddDate(0, 0, -1)
+	history, err := b.haClient.GetHistory(entityIDs, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get energy history: %w", err)
+	}
+
+	type usage struct {
+		name  string
+		value float64
+		unit  string
+	}
+	var usages []usage
+	units := make(map[string]bool)
+	var total float64
+	for _, entityID := range entityIDs {
+		value, ok := meterIncrease(history[entityID])
+		if !ok {
+			continue
+		}
+		state := byID[entityID]
+		u := usage{name: friendlyName(state), value: value, unit: state.Unit()}
+		if u.name == "" {
+			u.name = entityID
+		}
+		usages = append(usages, u)
+		units[u.unit] = true
+		total += value
+	}
+	if len(usages) == 0 {
+		return []string{"No data"}, nil
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].value > usages[j].value
+	})
+	var lines []string
+	if len(usages) > 1 && len(units) == 1 {
+		lines = append(lines, fmt.Sprintf("Total: <b>%s %s</b>", formatNumber(total), html.EscapeString(usages[0].unit)))
+	}
+	for _, u := range usages {
+		lines = append(lines, fmt.Sprintf("%s: %s %s", html.EscapeString(u.name), formatNumber(u.value), html.EscapeString(u.unit)))
+	}
+	return lines, nil
+}
+
+func isEnergyMeter(state ha.StateResponse) bool {
+	deviceClass, _ := state.Attributes["device_class"].(string)
+	stateClass, _ := state.Attributes["state_class"].(string)
+	return entityDomain(state.EntityID) == "sensor" &&
+		deviceClass == "energy" &&
+		(stateClass == "total" || stateClass == "total_increasing")
+}
+
+// meterIncrease returns how much a cumulative meter grew, a drop is taken
+// as a reset so the new value counts as consumption since the reset
+func meterIncrease(history []ha.HistoryState) (float64, bool) {
+	var total, previous float64
+	found := false
+	for _, h := range history {
+		value, err := strconv.ParseFloat(h.State, 64)
+		if err != nil {
+			continue
+		}
+		if found {
+			if value >= previous {
+				total += value - previous
+			} else {
+				total += value
+			}
+		}
+		previous, found = value, true
+	}
+	return total, found
+}
+
+// temperatureSection returns the minimum and maximum of the outdoor
+// temperature over the last day with the times they were reached
+func (b *Bot) temperatureSection(entityID string, now time.Time) ([]string, error) {
+	if entityID == "" {
+		return []string{"Set temperature_entity of the digest"}, nil
+	}
+
+	state, err := b.haClient.GetState(entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", entityID, err)
+	}
+	history, err := b.haClient.GetHistory([]string{entityID}, now.Add(-24*time.Hour), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get temperature history: %w", err)
+	}
+
+	var lo, hi *ha.HistoryState
+	var loValue, hiValue float64
+	for i, h := range history[entityID] {
+		value, err := strconv.ParseFloat(h.State, 64)
+		if err != nil {
+			continue
+		}
+		if lo == nil || value < loValue {
+			lo, loValue = &history[entityID][i], value
+		}
+		if hi == nil || value > hiValue {
+			hi, hiValue = &history[entityID][i], value
+		}
+	}
+	if lo == nil {
+		return []string{"No data"}, nil
+	}
+
+	unit := html.EscapeString(state.Unit())
+	return []string{
+		fmt.Sprintf("Min: %s %s at %s", formatNumber(loValue), unit, clampTime(lo.LastChanged, now.Add(-24*time.Hour), now).Local().Format("15:04")),
+		fmt.Sprintf("Max: %s %s at %s", formatNumber(hiValue), unit, clampTime(hi.LastChanged, now.Add(-24*time.Hour), now).Local().Format("15:04")),
+		fmt.Sprintf("Now: %s %s", html.EscapeString(state.State), unit),
+	}, nil
+}
+
+// devicesSection lists devices of the devices collection that are left on,
+// open or unlocked
+func (b *Bot) devicesSection(states []ha.StateResponse, now time.Time) ([]string, error) {
+	devices, err := b.getDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	byID := make(map[string]ha.StateResponse)
+	for _, state := range states {
+		byID[state.EntityID] = state
+	}
+
+	var lines []string
+	for _, device := range devices {
+		state, ok := byID[device.EntityID]
+		if !ok || !leftOn(device.Type, state.State) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s for %s",
+			getDeviceIcon(device.Type), html.EscapeString(device.Name), html.EscapeString(state.State), formatDuration(now.Sub(state.LastChanged))))
+	}
+	if len(lines) == 0 {
+		return []string{"Everything is off"}, nil
+	}
+	return lines, nil
+}
+
+// leftOn reports whether the state of a device is worth a reminder
+func leftOn(domain, state string) bool {
+	switch domain {
+	case "light", "switch", "fan", "input_boolean":
+		return state == "on"
+	case "media_player":
+		return state == "on" || state == "playing"
+	case "cover":
+		return state == "open"
+	case "lock":
+		return state == "unlocked"
+	default:
+		return false
+	}
+}
+
+// batteriesSection lists battery sensors below the threshold and binary
+// battery sensors reporting low, lowest first
+func batteriesSection(states []ha.StateResponse, threshold float64) []string {
+	type battery struct {
+		name  string
+		level float64
+	}
+	var low []battery
+	for _, state := range states {
+		if deviceClass, _ := state.Attributes["device_class"].(string); deviceClass != "battery" {
+			continue
+		}
+		switch entityDomain(state.EntityID) {
+		case "sensor":
+			level, err := strconv.ParseFloat(state.State, 64)
+			if err == nil && level < threshold {
+				low = append(low, battery{name: friendlyName(state), level: level})
+			}
+		case "binary_sensor":
+			if state.State == "on" {
+				low = append(low, battery{name: friendlyName(state), level: -1})
+			}
+		}
+	}
+	if len(low) == 0 {
+		return []string{fmt.Sprintf("All batteries above %s%%", formatNumber(threshold))}
+	}
+
+	sort.SliceStable(low, func(i, j int) bool {
+		return low[i].level < low[j].level
+	})
+	lines := make([]string, 0, len(low))
+	for _, battery := range low {
+		level := "low"
+		if battery.level >= 0 {
+			level = formatNumber(battery.level) + "%"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", html.EscapeString(battery.name), level))
+	}
+	return lines
+}
+
+// limitLines keeps at most limit lines, noting how many were left out
+func limitLines(lines []string, limit int) []string {
+	if len(lines) <= limit {
+		return lines
+	}
+	return append(slices.Clone(lines[:limit-1]), fmt.Sprintf("… and %d more", len(lines)-limit+1))
+}
+
+// splitList splits a comma separated list dropping empty items
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -17,13 +17,14 @@ var (
 )
 
 func (b *Bot) newGPTChat(c tele.Context) error {
+	loc := messageLocation(c.Message())
 
 	convo, err := b.librechatClient.MongoCreateConversation(librechat.EndpointOpenAI)
 	if err != nil {
 		return err
 	}
 
-	err = b.setState(map[string]any{"convo": convo, "chat_state": "gpt"})
+	err = b.setState(map[string]any{loc.convoKey(): convo, "chat_state": "gpt"})
 	if err != nil {
 		return err
 	}
@@ -33,10 +34,11 @@ func (b *Bot) newGPTChat(c tele.Context) error {
 
 func (b *Bot) handleGPTMessage(c tele.Context) error {
 	var (
-		txt = c.Text()
+		txt = b.stripMention(c.Text())
+		loc = messageLocation(c.Message())
 	)
 
-	convoID, err := b.getState("convo")
+	convoID, err := b.activeConversation(loc)
 	if err != nil {
 		return c.Send("Unable to get conversation from DB")
 	}
@@ -74,6 +76,9 @@ func (b *Bot) handleGPTMessage(c tele.Context) error {
 		Content: "You are a helpful assistant. Keep your responses concise and to the point.",
 	})
 
+	// Add content of the links from the current message
+	completionMessages = append(completionMessages, b.urlContextMessages(c.Message())...)
+
 	for i := range messages {
 		role := gpts.RoleAssistant
 		if messages[i].IsCreatedByUser {
@@ -123,6 +128,36 @@ func (b *Bot) handleGPTMessage(c tele.Context) error {
 
 }
 
+// activeConversation returns the conversation of the location, starting
+// a new one if there is none yet.
+func (b *Bot) activeConversation(loc location) (string, error) {
+	convoID, err := b.getState(loc.convoKey())
+	if err != nil {
+		return "", err
+	}
+
+	// Conversations of the GPT thread used to be stored under a single key
+	if convoID == "" && b.gptThreadID != 0 && loc.chatID == b.supergroupID && loc.threadID == int(b.gptThreadID) {
+		convoID, err = b.getState("convo")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if convoID != "" {
+		return convoID, nil
+	}
+
+	convoID, err = b.librechatClient.MongoCreateConversation(librechat.EndpointOpenAI)
+	if err != nil {
+		return "", err
+	}
+	if err := b.setState(map[string]any{loc.convoKey(): convoID}); err != nil {
+		return "", err
+	}
+	return convoID, nil
+}
+
 func (b *Bot) summarizeConversation(convoID string, userMessage string, gptResponse string) {
 	provider := gpts.NewProvider(gpts.OpenAI, b.openAIAPIKey)
 
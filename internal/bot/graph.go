@@ -0,0 +1,262 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/biozz-dev-bot/internal/chart"
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	defaultGraphPeriod = 24 * time.Hour
+	maxGraphPeriod     = 30 * 24 * time.Hour
+	maxGraphEntities   = 8
+	// captionLimit is the Telegram limit for photo captions
+	captionLimit = 1024
+)
+
+var graphPeriodRe = regexp.MustCompile(`^(\d+)([hd])$`)
+
+// graphEntity is an entity prepared for the chart and the caption
+type graphEntity struct {
+	// name is the friendly name for the caption, the chart is labeled with
+	// entity IDs as its font can't draw non-ASCII names
+	name     string
+	unit     string
+	numeric  bool
+	series   chart.Series
+	timeline chart.Timeline
+}
+
+func (b *Bot) handleGraph(c tele.Context) error {
+	args := strings.Fields(c.Message().Payload)
+	if len(args) == 0 {
+		return c.Reply("Usage: /graph <entity_id> [entity_id...] [24h|7d]")
+	}
+
+	period := defaultGraphPeriod
+	if p, ok := parseGraphPeriod(args[len(args)-1]); ok {
+		period = p
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 {
+		return c.Reply("Usage: /graph <entity_id> [entity_id...] [24h|7d]")
+	}
+	if len(args) > maxGraphEntities {
+		return c.Reply(fmt.Sprintf("❌ At most %d entities fit into a graph", maxGraphEntities))
+	}
+
+	c.Notify(tele.UploadingPhoto)
+
+	states := make(map[string]*ha.StateResponse, len(args))
+	for _, entityID := range args {
+		state, err := b.haClient.GetState(entityID)
+		if err != nil {
+			b.app.Logger().Warn("Error getting state for graph", "error", err, "entity_id", entityID)
+			return c.Reply(fmt.Sprintf("❌ Entity %s not found", entityID))
+		}
+		states[entityID] = state
+	}
+
+	end := time.Now()
+	start := end.Add(-period)
+	history, err := b.haClient.GetHistory(args, start, end)
+	if err != nil {
+		b.app.Logger().Error("Error getting history", "error", err)
+		return c.Reply("❌ Error getting history from Home Assistant")
+	}
+
+	var (
+		graph   = chart.Chart{Start: start, End: end}
+		caption []string
+	)
+	for _, entityID := range args {
+		entity := newGraphEntity(*states[entityID], history[entityID], start, end)
+		if entity.numeric {
+			graph.Series = append(graph.Series, entity.series)
+			caption = append(caption, numericSummary(entity.name, entity.series, end))
+		} else {
+			graph.Timelines = append(graph.Timelines, entity.timeline)
+			caption = append(caption, timelineSummary(entity.name, entity.timeline))
+		}
+	}
+
+	graph.Title = "Last " + formatPeriod(period)
+	if len(args) == 1 {
+		graph.Title = fmt.Sprintf("%s, %s", args[0], graph.Title)
+	}
+
+	var buf bytes.Buffer
+	if err := chart.Render(&buf, graph); err != nil {
+		b.app.Logger().Error("Error rendering graph", "error", err)
+		return c.Reply("❌ Error rendering graph")
+	}
+
+	return c.Send(&tele.Photo{
+		File:    tele.FromReader(&buf),
+		Caption: truncate(strings.Join(caption, "\n"), captionLimit),
+	})
+}
+
+// newGraphEntity turns the history into a line series if the states are
+// numeric and into a timeline otherwise. Binary sensors are always timelines.
+func newGraphEntity(state ha.StateResponse, history []ha.HistoryState, start, end time.Time) graphEntity {
+	entity := graphEntity{name: friendlyName(state)}
+	if unit, ok := state.Attributes["unit_of_measurement"].(string); ok {
+		entity.unit = unit
+	}
+
+	var numeric, total int
+	for _, h := range history {
+		if h.State == "unavailable" || h.State == "unknown" {
+			continue
+		}
+		total++
+		if _, err := strconv.ParseFloat(h.State, 64); err == nil {
+			numeric++
+		}
+	}
+	entity.numeric = entityDomain(state.EntityID) != "binary_sensor" && total > 0 && numeric*2 >= total
+
+	if entity.numeric {
+		entity.series = chart.Series{Name: state.EntityID, Unit: entity.unit}
+		for _, h := range history {
+			value, err := strconv.ParseFloat(h.State, 64)
+			if err != nil {
+				continue
+			}
+			entity.series.Points = append(entity.series.Points, chart.Point{Time: clampTime(h.LastChanged, start, end), Value: value})
+		}
+		return entity
+	}
+
+	entity.timeline = chart.Timeline{Name: state.EntityID}
+	for i, h := range history {
+		segmentEnd := end
+		if i+1 < len(history) {
+			segmentEnd = history[i+1].LastChanged
+		}
+		entity.timeline.Segments = append(entity.timeline.Segments, chart.Segment{
+			Start: clampTime(h.LastChanged, start, end),
+			End:   clampTime(segmentEnd, start, end),
+			State: h.State,
+		})
+	}
+	return entity
+}
+
+// numericSummary returns min, max and the time weighted average of the series
+func numericSummary(name string, series chart.Series, end time.Time) string {
+	if len(series.Points) == 0 {
+		return fmt.Sprintf("%s: no data", name)
+	}
+
+	lo, hi := series.Points[0].Value, series.Points[0].Value
+	var sum, weights float64
+	for i, p := range series.Points {
+		lo, hi = min(lo, p.Value), max(hi, p.Value)
+		next := end
+		if i+1 < len(series.Points) {
+			next = series.Points[i+1].Time
+		}
+		weight := next.Sub(p.Time).Seconds()
+		sum += p.Value * weight
+		weights += weight
+	}
+	avg := series.Points[len(series.Points)-1].Value
+	if weights > 0 {
+		avg = sum / weights
+	}
+
+	unit := ""
+	if series.Unit != "" {
+		unit = " " + series.Unit
+	}
+	return fmt.Sprintf("%s: min %s, max %s, avg %s%s", name, formatNumber(lo), formatNumber(hi), formatNumber(avg), unit)
+}
+
+// timelineSummary returns how long and how many times the entity was on,
+// for other states the time spent in the most common state
+func timelineSummary(name string, timeline chart.Timeline) string {
+	durations := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for _, segment := range timeline.Segments {
+		durations[segment.State] += segment.End.Sub(segment.Start)
+		counts[segment.State]++
+	}
+
+	state := "on"
+	if _, ok := durations[state]; !ok {
+		var longest time.Duration
+		for s, d := range durations {
+			if d > longest && s != "unavailable" && s != "unknown" {
+				state, longest = s, d
+			}
+		}
+	}
+	if counts[state] == 0 {
+		return fmt.Sprintf("%s: no data", name)
+	}
+
+	return fmt.Sprintf("%s: %s for %s, %d times", name, state, formatDuration(durations[state]), counts[state])
+}
+
+func parseGraphPeriod(value string) (time.Duration, bool) {
+	match := graphPeriodRe.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	period := time.Duration(n) * time.Hour
+	if match[2] == "d" {
+		period *= 24
+	}
+	return min(period, maxGraphPeriod), true
+}
+
+func formatPeriod(period time.Duration) string {
+	if period > 24*time.Hour && period%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int(period.Hours()/24))
+	}
+	return fmt.Sprintf("%dh", int(period.Hours()))
+}
+
+// formatDuration renders a duration with its two largest units, e.g. "3h 20m"
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd %dh", int(d.Hours()/24), int(d.Hours())%24)
+	}
+}
+
+// formatNumber rounds to one decimal, dropping a trailing zero
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+func clampTime(t, start, end time.Time) time.Time {
+	if t.Before(start) {
+		return start
+	}
+	if t.After(end) {
+		return end
+	}
+	return t
+}
@@ -0,0 +1,416 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// lightBrightnessStep is the brightness step in percent
+	lightBrightnessStep = 25
+	// lightColorTempStep is the color temperature step in kelvin
+	lightColorTempStep = 500
+	// mediaSourceLimit is the number of media player sources shown
+	mediaSourceLimit = 6
+)
+
+// coverPositions and fanPercentages are the preset buttons of the panels
+var (
+	coverPositions = []int{0, 25, 50, 75, 100}
+	fanPercentages = []int{25, 50, 75, 100}
+)
+
+// hasControlPanel reports whether the device button opens a control panel
+// instead of toggling the device right away
+func hasControlPanel(domain string) bool {
+	switch domain {
+	case "light", "climate", "cover", "media_player", "fan", "automation", "lock", "alarm_control_panel":
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Bot) handleDevicePanel(c tele.Context, cb haCallback, device *ha.Device) error {
+	if err := b.showDevicePanel(c, cb, device); err != nil {
+		b.app.Logger().Error("Error showing device panel", "error", err, "entity_id", device.EntityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error getting device state"})
+	}
+	return c.Respond()
+}
+
+func (b *Bot) handleDeviceCommand(c tele.Context, by actor, cb haCallback, device *ha.Device) error {
+	state, err := b.haClient.GetState(device.EntityID)
+	if err != nil {
+		b.app.Logger().Error("Error getting device state", "error", err, "entity_id", device.EntityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error getting device state"})
+	}
+
+	service, data, err := deviceCommand(device.Type, *state, cb.Cmd)
+	if err != nil {
+		b.app.Logger().Debug("Invalid device command", "error", err, "entity_id", device.EntityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	if err := b.callService(by, device, service, data); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("❌ Failed to control %s", device.Name)})
+	}
+
+	if err := b.showDevicePanel(c, cb, device); err != nil {
+		b.app.Logger().Error("Error updating device panel", "error", err)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "✅ Done"})
+}
+
+// showDevicePanel edits the keyboard message into the control panel of the device
+func (b *Bot) showDevicePanel(c tele.Context, cb haCallback, device *ha.Device) error {
+	state, err := b.haClient.GetState(device.EntityID)
+	if err != nil {
+		return err
+	}
+
+	title, keyboard := renderDevicePanel(cb, device, *state)
+
+	return editMessage(c, title, keyboard)
+}
+
+// renderDevicePanel returns the message text and keyboard of a device control panel
+func renderDevicePanel(cb haCallback, device *ha.Device, state ha.StateResponse) (string, *tele.ReplyMarkup) {
+	keyboard := &tele.ReplyMarkup{}
+	var rows []tele.Row
+
+	command := func(text, cmd string) tele.Btn {
+		btn := cb
+		btn.Action, btn.Cmd = haActionCommand, cmd
+		return btn.button(keyboard, text)
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s %s", getDeviceIcon(device.Type), device.Name),
+		"",
+		"State: " + formatState(device.Type, state),
+	}
+
+	switch device.Type {
+	case "light":
+		rows = append(rows, keyboard.Row(command("⏻ Toggle", "toggle")))
+		rows = append(rows, keyboard.Row(
+			command(fmt.Sprintf("🔅 -%d%%", lightBrightnessStep), fmt.Sprintf("bri=-%d", lightBrightnessStep)),
+			command(fmt.Sprintf("🔆 +%d%%", lightBrightnessStep), fmt.Sprintf("bri=+%d", lightBrightnessStep)),
+		))
+		if supportsColorTemp(state) {
+			if kelvin, ok := floatAttr(state, "color_temp_kelvin"); ok {
+				lines = append(lines, fmt.Sprintf("Color temperature: %.0fK", kelvin))
+			}
+			rows = append(rows, keyboard.Row(
+				command("🔥 Warmer", fmt.Sprintf("ct=-%d", lightColorTempStep)),
+				command("❄️ Cooler", fmt.Sprintf("ct=+%d", lightColorTempStep)),
+			))
+		}
+	case "climate":
+		step := climateTempStep(state)
+		target, hasTarget := floatAttr(state, "temperature")
+		low, high, hasRange := climateTempRange(state)
+		switch {
+		case hasTarget:
+			lines = append(lines, fmt.Sprintf("Target: %.1f°", target))
+		case hasRange:
+			lines = append(lines, fmt.Sprintf("Target: %.1f–%.1f°", low, high))
+		}
+		// Modes like off have no target to change
+		if hasTarget || hasRange {
+			rows = append(rows, keyboard.Row(
+				command(fmt.Sprintf("➖ %g°", step), "temp=-1"),
+				command(fmt.Sprintf("➕ %g°", step), "temp=+1"),
+			))
+		}
+		var modes []tele.Btn
+		for _, mode := range stringsAttr(state, "hvac_modes") {
+			text := mode
+			if mode == state.State {
+				text = "✅ " + mode
+			}
+			modes = append(modes, command(text, "hvac="+mode))
+		}
+		rows = append(rows, chunkButtons(keyboard, modes, 3)...)
+	case "cover":
+		rows = append(rows, keyboard.Row(
+			command("⬆️ Open", "open"),
+			command("⏹ Stop", "stop"),
+			command("⬇️ Close", "close"),
+		))
+		if position, ok := floatAttr(state, "current_position"); ok {
+			lines = append(lines, fmt.Sprintf("Position: %.0f%%", position))
+			var positions []tele.Btn
+			for _, p := range coverPositions {
+				positions = append(positions, command(fmt.Sprintf("%d%%", p), fmt.Sprintf("pos=%d", p)))
+			}
+			rows = append(rows, keyboard.Row(positions...))
+		}
+	case "media_player":
+		if title, ok := state.Attributes["media_title"].(string); ok && title != "" {
+			if artist, ok := state.Attributes["media_artist"].(string); ok && artist != "" {
+				title = fmt.Sprintf("%s — %s", artist, title)
+			}
+			lines = append(lines, "Playing: "+title)
+		}
+		if volume, ok := floatAttr(state, "volume_level"); ok {
+			lines = append(lines, fmt.Sprintf("Volume: %.0f%%", volume*100))
+		}
+		if source, ok := state.Attributes["source"].(string); ok && source != "" {
+			lines = append(lines, "Source: "+source)
+		}
+
+		mute := "🔇"
+		if muted, _ := state.Attributes["is_volume_muted"].(bool); muted {
+			mute = "🔈"
+		}
+		rows = append(rows, keyboard.Row(
+			command("⏮", "prev"),
+			command("⏯", "play"),
+			command("⏭", "next"),
+		))
+		rows = append(rows, keyboard.Row(
+			command("🔉", "vol=-"),
+			command(mute, "mute"),
+			command("🔊", "vol=+"),
+			command("⏻", "toggle"),
+		))
+		var sources []tele.Btn
+		for i, source := range stringsAttr(state, "source_list") {
+			if i == mediaSourceLimit {
+				break
+			}
+			sources = append(sources, command(source, fmt.Sprintf("src=%d", i)))
+		}
+		rows = append(rows, chunkButtons(keyboard, sources, 2)...)
+	case "automation":
+		lines = append(lines, "Last triggered: "+formatAgo(lastTriggered(state)))
+		rows = append(rows, keyboard.Row(
+			command("⏻ Toggle", "toggle"),
+			command("⚡ Trigger", "trigger"),
+		))
+	case "lock":
+		rows = append(rows, keyboard.Row(
+			command("🔒 Lock", "lock"),
+			command("🔓 Unlock", "unlock"),
+		))
+	case "alarm_control_panel":
+		rows = append(rows, keyboard.Row(
+			command("🏠 Arm home", "arm=home"),
+			command("🚗 Arm away", "arm=away"),
+			command("🌙 Arm night", "arm=night"),
+		))
+		rows = append(rows, keyboard.Row(command("🔓 Disarm", "disarm")))
+	case "fan":
+		rows = append(rows, keyboard.Row(
+			command("➖", "speed=-"),
+			command("⏻ Toggle", "toggle"),
+			command("➕", "speed=+"),
+		))
+		if _, ok := floatAttr(state, "percentage"); ok {
+			var speeds []tele.Btn
+			for _, p := range fanPercentages {
+				speeds = append(speeds, command(fmt.Sprintf("%d%%", p), fmt.Sprintf("pct=%d", p)))
+			}
+			rows = append(rows, keyboard.Row(speeds...))
+		}
+	}
+
+	back := cb
+	back.Action, back.Device, back.Cmd = haActionView, "", ""
+	refresh := cb
+	refresh.Action, refresh.Cmd = haActionPanel, ""
+	rows = append(rows, keyboard.Row(
+		back.button(keyboard, "⬅️ Back"),
+		refresh.button(keyboard, "🔄 Refresh"),
+	))
+
+	keyboard.Inline(rows...)
+	return strings.Join(lines, "\n"), keyboard
+}
+
+// deviceCommand translates a control panel command into a service call of
+// the device domain, relative commands are resolved against the current state
+func deviceCommand(domain string, state ha.StateResponse, cmd string) (string, map[string]any, error) {
+	name, arg, _ := strings.Cut(cmd, "=")
+
+	switch domain + ":" + name {
+	case "light:toggle", "media_player:toggle", "fan:toggle", "automation:toggle":
+		return "toggle", nil, nil
+
+	case "automation:trigger":
+		return "trigger", map[string]any{"skip_condition": true}, nil
+
+	case "light:bri":
+		step, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid brightness step %q", arg)
+		}
+		return "turn_on", map[string]any{"brightness_step_pct": step}, nil
+
+	case "light:ct":
+		step, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid color temperature step %q", arg)
+		}
+		minKelvin, _ := floatAttr(state, "min_color_temp_kelvin")
+		maxKelvin, _ := floatAttr(state, "max_color_temp_kelvin")
+		current, ok := floatAttr(state, "color_temp_kelvin")
+		if !ok {
+			// Lights that are off or in a color mode have no color temperature
+			current = (minKelvin + maxKelvin) / 2
+		}
+		kelvin := current + step
+		if minKelvin > 0 && maxKelvin > 0 {
+			kelvin = min(max(kelvin, minKelvin), maxKelvin)
+		}
+		return "turn_on", map[string]any{"color_temp_kelvin": int(kelvin)}, nil
+
+	case "climate:temp":
+		steps, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid temperature step %q", arg)
+		}
+		delta := steps * climateTempStep(state)
+		if target, ok := floatAttr(state, "temperature"); ok {
+			return "set_temperature", map[string]any{"temperature": clampClimateTemp(state, target+delta)}, nil
+		}
+		// heat_cool mode has a range instead of a single target, it is shifted as a whole
+		if low, high, ok := climateTempRange(state); ok {
+			return "set_temperature", map[string]any{
+				"target_temp_low":  clampClimateTemp(state, low+delta),
+				"target_temp_high": clampClimateTemp(state, high+delta),
+			}, nil
+		}
+		return "", nil, errors.New("climate entity has no target temperature")
+
+	case "climate:hvac":
+		if !slices.Contains(stringsAttr(state, "hvac_modes"), arg) {
+			return "", nil, fmt.Errorf("unsupported hvac mode %q", arg)
+		}
+		return "set_hvac_mode", map[string]any{"hvac_mode": arg}, nil
+
+	case "cover:open":
+		return "open_cover", nil, nil
+	case "cover:close":
+		return "close_cover", nil, nil
+	case "cover:stop":
+		return "stop_cover", nil, nil
+	case "cover:pos":
+		position, err := strconv.Atoi(arg)
+		if err != nil || position < 0 || position > 100 {
+			return "", nil, fmt.Errorf("invalid cover position %q", arg)
+		}
+		return "set_cover_position", map[string]any{"position": position}, nil
+
+	case "media_player:play":
+		return "media_play_pause", nil, nil
+	case "media_player:prev":
+		return "media_previous_track", nil, nil
+	case "media_player:next":
+		return "media_next_track", nil, nil
+	case "media_player:vol":
+		if arg == "-" {
+			return "volume_down", nil, nil
+		}
+		return "volume_up", nil, nil
+	case "media_player:mute":
+		muted, _ := state.Attributes["is_volume_muted"].(bool)
+		return "volume_mute", map[string]any{"is_volume_muted": !muted}, nil
+	case "media_player:src":
+		// Sources are referenced by index to fit into the callback data
+		sources := stringsAttr(state, "source_list")
+		i, err := strconv.Atoi(arg)
+		if err != nil || i < 0 || i >= len(sources) {
+			return "", nil, fmt.Errorf("invalid source %q", arg)
+		}
+		return "select_source", map[string]any{"source": sources[i]}, nil
+
+	case "lock:lock", "lock:unlock":
+		return name, nil, nil
+
+	case "alarm_control_panel:arm":
+		switch arg {
+		case "home", "away", "night":
+			return "alarm_arm_" + arg, nil, nil
+		}
+		return "", nil, fmt.Errorf("invalid arm mode %q", arg)
+	case "alarm_control_panel:disarm":
+		return "alarm_disarm", nil, nil
+
+	case "fan:speed":
+		if arg == "-" {
+			return "decrease_speed", nil, nil
+		}
+		return "increase_speed", nil, nil
+	case "fan:pct":
+		percentage, err := strconv.Atoi(arg)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return "", nil, fmt.Errorf("invalid fan percentage %q", arg)
+		}
+		return "set_percentage", map[string]any{"percentage": percentage}, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown command %q for %s", cmd, domain)
+}
+
+func supportsColorTemp(state ha.StateResponse) bool {
+	return slices.Contains(stringsAttr(state, "supported_color_modes"), "color_temp")
+}
+
+func climateTempStep(state ha.StateResponse) float64 {
+	if step, ok := floatAttr(state, "target_temp_step"); ok && step > 0 {
+		return step
+	}
+	return 0.5
+}
+
+// climateTempRange returns the targets of the heat_cool mode
+func climateTempRange(state ha.StateResponse) (float64, float64, bool) {
+	low, lowOK := floatAttr(state, "target_temp_low")
+	high, highOK := floatAttr(state, "target_temp_high")
+	return low, high, lowOK && highOK
+}
+
+func clampClimateTemp(state ha.StateResponse, temp float64) float64 {
+	if minTemp, ok := floatAttr(state, "min_temp"); ok {
+		temp = max(temp, minTemp)
+	}
+	if maxTemp, ok := floatAttr(state, "max_temp"); ok {
+		temp = min(temp, maxTemp)
+	}
+	return temp
+}
+
+func floatAttr(state ha.StateResponse, key string) (float64, bool) {
+	value, ok := state.Attributes[key].(float64)
+	return value, ok
+}
+
+func stringsAttr(state ha.StateResponse, key string) []string {
+	values, _ := state.Attributes[key].([]any)
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// chunkButtons lays out buttons in rows of the given size
+func chunkButtons(keyboard *tele.ReplyMarkup, buttons []tele.Btn, size int) []tele.Row {
+	var rows []tele.Row
+	for chunk := range slices.Chunk(buttons, size) {
+		rows = append(rows, keyboard.Row(chunk...))
+	}
+	return rows
+}
@@ -0,0 +1,407 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/pocketbase/core"
+	"golang.org/x/crypto/bcrypt"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// haConfirmTimeout is how long a confirmation prompt stays valid
+	haConfirmTimeout = 30 * time.Second
+	// haPINAttempts is the number of wrong codes closing a prompt
+	haPINAttempts  = 3
+	haPINMaxLength = 12
+)
+
+// Sources of audited service calls
+const (
+	haSourceKeyboard   = "ha"
+	haSourceSchedule   = "schedule"
+	haSourceScene      = "scene"
+	haSourceScript     = "script"
+	haSourceAutomation = "automation"
+)
+
+// actor is who triggered a service call, user is nil for background jobs
+type actor struct {
+	source string
+	user   *tele.User
+	// code is the PIN the user entered, checked against the device PIN
+	code string
+}
+
+func userActor(source string, user *tele.User) actor {
+	return actor{source: source, user: user}
+}
+
+// pendingConfirmation is an action waiting for the confirm button
+type pendingConfirmation struct {
+	cb      haCallback
+	expires time.Time
+	// run performs actions of the scene, script and automation keyboards
+	// instead of the device button of cb, back is the keyboard they came from
+	run  func(c tele.Context) error
+	back *hsCallback
+	// text is the prompt, code the digits entered on the keypad so far
+	text     string
+	code     string
+	attempts int
+}
+
+// askConfirmation replaces the keyboard with a confirmation prompt, the
+// action is kept in memory under a token carried by the prompt buttons.
+// Devices with a PIN get a keypad, the code is entered before confirming.
+func (b *Bot) askConfirmation(c tele.Context, cb haCallback, device *ha.Device) error {
+	return b.promptConfirmation(c, &pendingConfirmation{cb: cb}, device, b.describeAction(cb, device))
+}
+
+// guardEntityAction applies the policies of the device of a scene, script
+// or automation to an action of their keyboards, the action runs once it
+// is within the allowed hours and confirmed if the device needs it
+func (b *Bot) guardEntityAction(c tele.Context, device *ha.Device, description string, back hsCallback, run func(c tele.Context) error) error {
+	if err := checkAllowedHours(device, time.Now()); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ " + err.Error(), ShowAlert: true})
+	}
+	if !device.Confirm && device.PINHash == "" {
+		return run(c)
+	}
+
+	// The confirm buttons are handled by the /ha keyboard of the device
+	cb := haCallback{Action: haActionDevice, View: haViewFavorites, Device: device.ID}
+	return b.promptConfirmation(c, &pendingConfirmation{cb: cb, run: run, back: &back}, device, description)
+}
+
+func (b *Bot) promptConfirmation(c tele.Context, pending *pendingConfirmation, device *ha.Device, description string) error {
+	token, err := confirmToken()
+	if err != nil {
+		return err
+	}
+
+	pending.text = fmt.Sprintf("⚠️ %s %s\n\n%s?\nConfirm within %d seconds",
+		getDeviceIcon(device.Type), device.Name, description, int(haConfirmTimeout.Seconds()))
+	pending.expires = time.Now().Add(haConfirmTimeout)
+
+	b.confirmMu.Lock()
+	b.confirmations[token] = pending
+	b.confirmMu.Unlock()
+
+	text, keyboard := renderConfirmation(pending, token, device)
+	if err := editMessage(c, text, keyboard); err != nil {
+		b.app.Logger().Error("Error showing confirmation", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error asking for confirmation"})
+	}
+
+	// Expired prompts are replaced with a way back to the keyboard
+	msg := c.Message()
+	time.AfterFunc(haConfirmTimeout, func() {
+		if _, ok := b.popConfirmation(token); !ok {
+			return
+		}
+		b.closeConfirmation(msg, pending, "⌛ Expired")
+	})
+
+	return c.Respond()
+}
+
+// renderConfirmation returns the prompt with the confirm and cancel
+// buttons, below a keypad for devices with a PIN
+func renderConfirmation(pending *pendingConfirmation, token string, device *ha.Device) (string, *tele.ReplyMarkup) {
+	keyboard := &tele.ReplyMarkup{}
+	confirm, cancel := pending.cb, pending.cb
+	confirm.Action, confirm.Cmd = haActionConfirm, token
+	cancel.Action, cancel.Cmd = haActionCancel, token
+
+	if device.PINHash == "" {
+		keyboard.Inline(keyboard.Row(
+			confirm.button(keyboard, "✅ Confirm"),
+			cancel.button(keyboard, "✖ Cancel"),
+		))
+		return pending.text, keyboard
+	}
+
+	key := func(text, value string) tele.Btn {
+		cb := pending.cb
+		cb.Action, cb.Cmd = haActionPINKey, token+":"+value
+		return cb.button(keyboard, text)
+	}
+	var rows []tele.Row
+	for _, digits := range []string{"123", "456", "789"} {
+		var row tele.Row
+		for _, digit := range digits {
+			row = append(row, key(string(digit), string(digit)))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows,
+		keyboard.Row(key("⌫", "del"), key("0", "0"), confirm.button(keyboard, "✅")),
+		keyboard.Row(cancel.button(keyboard, "✖ Cancel")),
+	)
+	keyboard.Inline(rows...)
+
+	code := strings.Repeat("•", len(pending.code))
+	if code == "" {
+		code = "–"
+	}
+	return pending.text + "\n\n🔢 Code: " + code, keyboard
+}
+
+// closeConfirmation replaces the prompt keyboard with a way back
+func (b *Bot) closeConfirmation(msg tele.Editable, pending *pendingConfirmation, note string) {
+	keyboard := &tele.ReplyMarkup{}
+	if pending.back != nil {
+		keyboard.Inline(keyboard.Row(keyboard.Data("⬅️ Back", pending.back.String())))
+	} else {
+		back := confirmationOrigin(pending.cb)
+		keyboard.Inline(keyboard.Row(back.button(keyboard, "⬅️ Back")))
+	}
+	if _, err := b.bot.Edit(msg, pending.text+"\n\n"+note, keyboard); err != nil {
+		b.app.Logger().Debug("Error closing confirmation", "error", err)
+	}
+}
+
+// handlePINKey adds a digit to the code of a pending confirmation or
+// removes the last one
+func (b *Bot) handlePINKey(c tele.Context, cb haCallback, device *ha.Device) error {
+	token, key, _ := strings.Cut(cb.Cmd, ":")
+
+	b.confirmMu.Lock()
+	pending, ok := b.confirmations[token]
+	var current pendingConfirmation
+	if ok {
+		switch {
+		case key == "del" && pending.code != "":
+			pending.code = pending.code[:len(pending.code)-1]
+		case len(key) == 1 && key >= "0" && key <= "9" && len(pending.code) < haPINMaxLength:
+			pending.code += key
+		}
+		current = *pending
+	}
+	b.confirmMu.Unlock()
+
+	if !ok || time.Now().After(current.expires) {
+		return c.Respond(&tele.CallbackResponse{Text: "⌛ Confirmation expired"})
+	}
+
+	text, keyboard := renderConfirmation(&current, token, device)
+	if err := editMessage(c, text, keyboard); err != nil {
+		b.app.Logger().Debug("Error updating keypad", "error", err)
+	}
+	return c.Respond()
+}
+
+func (b *Bot) handleConfirmation(c tele.Context, cb haCallback, device *ha.Device) error {
+	pending, ok := b.popConfirmation(cb.Cmd)
+	if !ok || time.Now().After(pending.expires) {
+		return c.Respond(&tele.CallbackResponse{Text: "⌛ Confirmation expired"})
+	}
+
+	if cb.Action == haActionCancel {
+		origin := confirmationOrigin(pending.cb)
+		var err error
+		if pending.back != nil {
+			err = b.showEntityView(c, *pending.back)
+		} else if origin.Action == haActionPanel {
+			err = b.showDevicePanel(c, origin, device)
+		} else {
+			err = b.showHAView(c, origin)
+		}
+		if err != nil {
+			b.app.Logger().Error("Error updating device keyboard", "error", err)
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "✖ Cancelled"})
+	}
+
+	by := userActor(haSourceKeyboard, c.Sender())
+	if device.PINHash != "" {
+		if !checkPIN(device.PINHash, pending.code) {
+			return b.rejectPIN(c, cb.Cmd, pending, device)
+		}
+		by.code = pending.code
+	}
+
+	if pending.run != nil {
+		// The prompt is replaced with the keyboard the action came from
+		if err := b.showEntityView(c, *pending.back); err != nil {
+			b.app.Logger().Error("Error updating keyboard", "error", err)
+		}
+		return pending.run(c)
+	}
+	return b.runDeviceAction(c, by, pending.cb, device)
+}
+
+// rejectPIN clears the entered code and puts the confirmation back, the
+// prompt is closed after haPINAttempts wrong codes
+func (b *Bot) rejectPIN(c tele.Context, token string, pending *pendingConfirmation, device *ha.Device) error {
+	b.audit(userActor(haSourceKeyboard, c.Sender()), device.EntityID, "pin", nil, errors.New("wrong code"))
+
+	pending.attempts++
+	pending.code = ""
+	if pending.attempts >= haPINAttempts {
+		b.closeConfirmation(c.Message(), pending, "⛔ Wrong code")
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ Wrong code", ShowAlert: true})
+	}
+
+	b.confirmMu.Lock()
+	b.confirmations[token] = pending
+	b.confirmMu.Unlock()
+
+	text, keyboard := renderConfirmation(pending, token, device)
+	if err := editMessage(c, text, keyboard); err != nil {
+		b.app.Logger().Debug("Error updating keypad", "error", err)
+	}
+	return c.Respond(&tele.CallbackResponse{Text: "❌ Wrong code"})
+}
+
+// checkPIN compares the code with the bcrypt hash of the device PIN, the
+// comparison takes the same time for every wrong code
+func checkPIN(hash, code string) bool {
+	return code != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil
+}
+
+// hashDevicePINs stores PINs entered in the devices collection as bcrypt
+// hashes, so no usable code is kept in the database
+func (b *Bot) hashDevicePINs() {
+	hash := func(e *core.RecordEvent) error {
+		pin := e.Record.GetString("pin")
+		if pin == "" {
+			return e.Next()
+		}
+		if _, err := bcrypt.Cost([]byte(pin)); err == nil {
+			return e.Next()
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		e.Record.Set("pin", string(hashed))
+		return e.Next()
+	}
+	b.app.OnRecordCreate("devices").BindFunc(hash)
+	b.app.OnRecordUpdate("devices").BindFunc(hash)
+}
+
+func (b *Bot) popConfirmation(token string) (*pendingConfirmation, bool) {
+	b.confirmMu.Lock()
+	defer b.confirmMu.Unlock()
+
+	pending, ok := b.confirmations[token]
+	delete(b.confirmations, token)
+	return pending, ok
+}
+
+// confirmationOrigin returns the panel or view the confirmed action was
+// started from
+func confirmationOrigin(cb haCallback) haCallback {
+	if cb.Action == haActionCommand {
+		cb.Action, cb.Cmd = haActionPanel, ""
+		return cb
+	}
+	cb.Action, cb.Device, cb.Cmd = haActionView, "", ""
+	return cb
+}
+
+// describeAction returns the service call of a pending action for the prompt
+func (b *Bot) describeAction(cb haCallback, device *ha.Device) string {
+	if cb.Action != haActionCommand {
+		service, _ := directService(device.Type)
+		return humanizeKey(service)
+	}
+
+	state, err := b.haClient.GetState(device.EntityID)
+	if err != nil {
+		return cb.Cmd
+	}
+	service, data, err := deviceCommand(device.Type, *state, cb.Cmd)
+	if err != nil {
+		return cb.Cmd
+	}
+
+	description := humanizeKey(service)
+	for key, value := range data {
+		description += fmt.Sprintf(", %s %v", strings.ReplaceAll(key, "_", " "), value)
+	}
+	return description
+}
+
+// checkAllowedHours fails if the device may not be controlled at the time,
+// allowed_hours is a range like "07:00-23:00", ranges can span midnight
+func checkAllowedHours(device *ha.Device, now time.Time) error {
+	if device.AllowedHours == "" {
+		return nil
+	}
+
+	from, to, ok := strings.Cut(device.AllowedHours, "-")
+	if !ok {
+		return fmt.Errorf("invalid allowed hours %q of %s", device.AllowedHours, device.Name)
+	}
+	fromHour, fromMinute, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return fmt.Errorf("invalid allowed hours %q of %s", device.AllowedHours, device.Name)
+	}
+	toHour, toMinute, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return fmt.Errorf("invalid allowed hours %q of %s", device.AllowedHours, device.Name)
+	}
+
+	start, end := fromHour*60+fromMinute, toHour*60+toMinute
+	minute := now.Hour()*60 + now.Minute()
+
+	allowed := minute >= start && minute < end
+	if start > end {
+		allowed = minute >= start || minute < end
+	}
+	if !allowed {
+		return fmt.Errorf("%s can only be controlled between %s", device.Name, device.AllowedHours)
+	}
+	return nil
+}
+
+// usesCode reports whether services of the domain accept a code
+func usesCode(domain string) bool {
+	return domain == "lock" || domain == "alarm_control_panel"
+}
+
+// audit records a service call in the audit collection, failures to write
+// the record are only logged
+func (b *Bot) audit(by actor, entityID, service string, data map[string]any, callErr error) {
+	collection, err := b.app.FindCollectionByNameOrId("audit")
+	if err != nil {
+		b.app.Logger().Error("Error finding audit collection", "error", err)
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("entity_id", entityID)
+	record.Set("service", entityDomain(entityID)+"."+service)
+	record.Set("data", data)
+	record.Set("source", by.source)
+	record.Set("success", callErr == nil)
+	if callErr != nil {
+		record.Set("error", callErr.Error())
+	}
+	if by.user != nil {
+		record.Set("user_id", by.user.ID)
+		record.Set("user_name", strings.TrimSpace(by.user.FirstName+" "+by.user.LastName))
+	}
+
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving audit record", "error", err, "entity_id", entityID)
+	}
+}
+
+func confirmToken() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
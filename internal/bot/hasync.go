@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+const haSyncTimeout = time.Minute
+
+// defaultSyncDomains are imported when HOME_ASSISTANT_SYNC_DOMAINS is not set
+var defaultSyncDomains = []string{
+	"light",
+	"switch",
+	"button",
+	"climate",
+	"cover",
+	"fan",
+	"media_player",
+	"lock",
+	"alarm_control_panel",
+	"scene",
+	"script",
+	"input_boolean",
+	"vacuum",
+	"camera",
+}
+
+type syncResult struct {
+	added   int
+	updated int
+	missing int
+}
+
+func (r syncResult) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d missing", r.added, r.updated, r.missing)
+}
+
+// syncRecord tracks whether a device record was seen during the sync
+type syncRecord struct {
+	record *core.Record
+	seen   bool
+}
+
+// startDeviceSync registers the periodic device sync if HOME_ASSISTANT_SYNC_CRON is set
+func (b *Bot) startDeviceSync() {
+	if b.haSyncCron == "" {
+		return
+	}
+
+	err := b.app.Cron().Add("ha_sync", b.haSyncCron, func() {
+		result, err := b.syncDevices()
+		if err != nil {
+			b.app.Logger().Error("Error syncing devices", "error", err)
+			return
+		}
+		b.app.Logger().Info("Synced devices", "result", result.String())
+	})
+	if err != nil {
+		b.app.Logger().Error("Error registering device sync", "error", err, "cron", b.haSyncCron)
+	}
+}
+
+func (b *Bot) handleHomeAssistantSync(c tele.Context) error {
+	c.Notify(tele.Typing)
+
+	result, err := b.syncDevices()
+	if err != nil {
+		b.app.Logger().Error("Error syncing devices", "error", err)
+		return c.Reply(fmt.Sprintf("❌ Error syncing devices: %v", err))
+	}
+
+	return c.Reply(fmt.Sprintf("✅ Devices synced: %s", result))
+}
+
+// syncDevices upserts entities from the Home Assistant registries into the
+// devices collection. Custom names, ordering and flags are kept, records
+// of entities that disappeared are flagged as missing.
+func (b *Bot) syncDevices() (syncResult, error) {
+	var result syncResult
+
+	ctx, cancel := context.WithTimeout(context.Background(), haSyncTimeout)
+	defer cancel()
+
+	areas, err := b.haWS.ListAreas(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list areas: %w", err)
+	}
+	registryDevices, err := b.haWS.ListDevices(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list devices: %w", err)
+	}
+	entities, err := b.haWS.ListEntities(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list entities: %w", err)
+	}
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		return result, fmt.Errorf("failed to get states: %w", err)
+	}
+
+	areaNames := make(map[string]string, len(areas))
+	for _, area := range areas {
+		areaNames[area.AreaID] = area.Name
+	}
+	deviceAreas := make(map[string]string, len(registryDevices))
+	for _, device := range registryDevices {
+		deviceAreas[device.ID] = device.AreaID
+	}
+	registry := make(map[string]ha.EntityRegistryEntry, len(entities))
+	for _, entity := range entities {
+		registry[entity.EntityID] = entity
+	}
+
+	collection, err := b.app.FindCollectionByNameOrId("devices")
+	if err != nil {
+		return result, err
+	}
+
+	records, err := b.app.FindAllRecords("devices")
+	if err != nil {
+		return result, err
+	}
+	existing := make(map[string]*syncRecord, len(records))
+	maxSort := 0
+	for _, record := range records {
+		existing[record.GetString("entity_id")] = &syncRecord{record: record}
+		maxSort = max(maxSort, record.GetInt("sort"))
+	}
+
+	for _, state := range states {
+		domain := entityDomain(state.EntityID)
+		if !slices.Contains(b.haSyncDomains, domain) {
+			continue
+		}
+
+		// Entities without a unique ID have no registry entry, they are kept
+		entry, inRegistry := registry[state.EntityID]
+		if inRegistry && (entry.DisabledBy != "" || entry.HiddenBy != "") {
+			continue
+		}
+
+		areaID := entry.AreaID
+		if areaID == "" {
+			areaID = deviceAreas[entry.DeviceID]
+		}
+
+		ref, ok := existing[state.EntityID]
+		if !ok {
+			maxSort++
+			ref = &syncRecord{record: core.NewRecord(collection)}
+			ref.record.Set("entity_id", state.EntityID)
+			ref.record.Set("name", friendlyName(state))
+			ref.record.Set("sort", maxSort)
+			result.added++
+		} else {
+			result.updated++
+		}
+		ref.seen = true
+
+		record := ref.record
+		record.Set("ha_name", friendlyName(state))
+		record.Set("domain", domain)
+		record.Set("area_id", areaID)
+		record.Set("area", areaNames[areaID])
+		record.Set("missing", false)
+		if record.GetString("name") == "" {
+			record.Set("name", friendlyName(state))
+		}
+
+		if err := b.app.Save(record); err != nil {
+			return result, fmt.Errorf("failed to save %s: %w", state.EntityID, err)
+		}
+	}
+
+	for entityID, ref := range existing {
+		if ref.seen || ref.record.GetBool("missing") {
+			continue
+		}
+		// Only entities of synced domains are known to be gone
+		if !slices.Contains(b.haSyncDomains, entityDomain(entityID)) {
+			continue
+		}
+		ref.record.Set("missing", true)
+		if err := b.app.Save(ref.record); err != nil {
+			return result, fmt.Errorf("failed to flag %s: %w", entityID, err)
+		}
+		result.missing++
+	}
+
+	return result, nil
+}
+
+func parseSyncDomains(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return defaultSyncDomains
+	}
+
+	return splitList(value)
+}
@@ -0,0 +1,423 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Conditions of the ha_watches collection
+const (
+	watchEquals      = "equals"
+	watchNotEquals   = "not_equals"
+	watchAbove       = "above"
+	watchBelow       = "below"
+	watchUnavailable = "unavailable"
+)
+
+const (
+	defaultWatchMessage    = "🚨 {{.Name}} is {{.State}}{{.Unit}}"
+	defaultRecoveryMessage = "✅ {{.Name}} is back to {{.State}}{{.Unit}}"
+)
+
+// watchSnoozeOptions are the snooze buttons attached to watch alerts
+var watchSnoozeOptions = []struct {
+	label   string
+	minutes int
+}{
+	{"😴 1h", 60},
+	{"😴 8h", 8 * 60},
+	{"😴 1d", 24 * 60},
+}
+
+// watchData is available in message and recovery message templates
+type watchData struct {
+	Name       string
+	EntityID   string
+	State      string
+	Previous   string
+	Unit       string
+	Value      string
+	Attributes map[string]any
+}
+
+// startWatcher evaluates ha_watches on every state change and once a
+// minute for conditions that have to hold for a while
+func (b *Bot) startWatcher() {
+	b.loadWatchedEntities()
+	reload := func(e *core.RecordEvent) error {
+		b.loadWatchedEntities()
+		return e.Next()
+	}
+	b.app.OnRecordAfterCreateSuccess("ha_watches").BindFunc(reload)
+	b.app.OnRecordAfterDeleteSuccess("ha_watches").BindFunc(reload)
+	// Watches are saved on every evaluation, only edits of the watched
+	// entity or the enabled flag change the cache
+	b.app.OnRecordAfterUpdateSuccess("ha_watches").BindFunc(func(e *core.RecordEvent) error {
+		original := e.Record.Original()
+		if original.GetString("entity_id") != e.Record.GetString("entity_id") ||
+			original.GetBool("enabled") != e.Record.GetBool("enabled") {
+			b.loadWatchedEntities()
+		}
+		return e.Next()
+	})
+
+	b.haWS.OnStateChanged(func(event ha.StateChangedEvent) {
+		b.watchedMu.RLock()
+		watched := b.watchedEntities[event.EntityID]
+		b.watchedMu.RUnlock()
+		if watched {
+			go b.handleWatchEvent(event)
+		}
+	})
+
+	b.app.Cron().MustAdd("ha_watches", "* * * * *", b.tickWatches)
+
+	// Entities that are already in the watched state won't produce events
+	go b.evaluateAllWatches()
+}
+
+// loadWatchedEntities caches entity IDs with enabled watches, so
+// state changes of other entities don't hit the database
+func (b *Bot) loadWatchedEntities() {
+	records, err := b.app.FindRecordsByFilter("ha_watches", "enabled = true", "", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading watches", "error", err)
+		return
+	}
+
+	watched := make(map[string]bool, len(records))
+	for _, record := range records {
+		watched[record.GetString("entity_id")] = true
+	}
+
+	b.watchedMu.Lock()
+	b.watchedEntities = watched
+	b.watchedMu.Unlock()
+}
+
+func (b *Bot) handleWatchEvent(event ha.StateChangedEvent) {
+	if event.NewState == nil {
+		return
+	}
+
+	records, err := b.app.FindRecordsByFilter(
+		"ha_watches",
+		"enabled = true && entity_id = {:entityID}",
+		"",
+		0,
+		0,
+		dbx.Params{"entityID": event.EntityID},
+	)
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	b.evaluateWatches(records, map[string]ha.StateChangedEvent{event.EntityID: event})
+}
+
+// evaluateAllWatches checks every enabled watch against the current states
+func (b *Bot) evaluateAllWatches() {
+	records, err := b.app.FindRecordsByFilter("ha_watches", "enabled = true", "", 0, 0)
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		b.app.Logger().Warn("Error getting states for watches", "error", err)
+		return
+	}
+
+	events := make(map[string]ha.StateChangedEvent, len(states))
+	for i := range states {
+		events[states[i].EntityID] = ha.StateChangedEvent{EntityID: states[i].EntityID, NewState: &states[i]}
+	}
+
+	b.evaluateWatches(records, events)
+}
+
+func (b *Bot) evaluateWatches(records []*core.Record, events map[string]ha.StateChangedEvent) {
+	b.watchMu.Lock()
+	defer b.watchMu.Unlock()
+
+	for _, record := range records {
+		event, ok := events[record.GetString("entity_id")]
+		if !ok {
+			continue
+		}
+		// The record might have been changed while waiting for the lock
+		record, err := b.app.FindRecordById("ha_watches", record.Id)
+		if err != nil {
+			continue
+		}
+		if err := b.evaluateWatch(record, event); err != nil {
+			b.app.Logger().Error("Error evaluating watch", "error", err, "watch", record.Id)
+		}
+	}
+}
+
+// evaluateWatch updates the watch with the new state, alerts go out once
+// the condition held for for_minutes, recoveries go out right away
+func (b *Bot) evaluateWatch(record *core.Record, event ha.StateChangedEvent) error {
+	state := event.NewState
+	matches := watchMatches(record, state.State)
+
+	if !matches {
+		firing := record.GetBool("firing")
+		if !firing && record.GetDateTime("pending_since").IsZero() {
+			return nil
+		}
+		if firing {
+			if err := b.sendWatchMessage(record, event, true); err != nil {
+				return err
+			}
+		}
+		record.Set("firing", false)
+		record.Set("pending_since", "")
+		return b.app.Save(record)
+	}
+
+	if record.GetBool("firing") {
+		return nil
+	}
+
+	if record.GetDateTime("pending_since").IsZero() {
+		since := state.LastChanged
+		if since.IsZero() {
+			since = time.Now()
+		}
+		record.Set("pending_since", since)
+		if err := b.app.Save(record); err != nil {
+			return err
+		}
+	}
+
+	return b.fireWatchIfDue(record, event)
+}
+
+// fireWatchIfDue sends the alert unless the watch is snoozed, cooling
+// down or the condition hasn't held for long enough yet
+func (b *Bot) fireWatchIfDue(record *core.Record, event ha.StateChangedEvent) error {
+	now := time.Now()
+
+	forDuration := time.Duration(record.GetInt("for_minutes")) * time.Minute
+	if now.Sub(record.GetDateTime("pending_since").Time()) < forDuration {
+		return nil
+	}
+
+	if snoozed := record.GetDateTime("snoozed_until"); !snoozed.IsZero() && now.Before(snoozed.Time()) {
+		return nil
+	}
+
+	cooldown := time.Duration(record.GetInt("cooldown_minutes")) * time.Minute
+	if last := record.GetDateTime("last_alert"); !last.IsZero() && now.Sub(last.Time()) < cooldown {
+		return nil
+	}
+
+	if err := b.sendWatchMessage(record, event, false); err != nil {
+		return err
+	}
+
+	record.Set("firing", true)
+	record.Set("last_alert", now)
+	return b.app.Save(record)
+}
+
+// tickWatches fires pending watches whose duration has passed
+func (b *Bot) tickWatches() {
+	records, err := b.app.FindRecordsByFilter(
+		"ha_watches",
+		"enabled = true && firing = false && pending_since != ''",
+		"",
+		0,
+		0,
+	)
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	events := make(map[string]ha.StateChangedEvent, len(records))
+	for _, record := range records {
+		entityID := record.GetString("entity_id")
+		state, err := b.haClient.GetState(entityID)
+		if err != nil {
+			b.app.Logger().Warn("Error getting state for watch", "error", err, "watch", record.Id)
+			continue
+		}
+		events[entityID] = ha.StateChangedEvent{EntityID: entityID, NewState: state}
+	}
+
+	b.evaluateWatches(records, events)
+}
+
+func watchMatches(record *core.Record, state string) bool {
+	value := record.GetString("value")
+
+	switch record.GetString("condition") {
+	case watchEquals:
+		return state == value
+	case watchNotEquals:
+		return state != value
+	case watchAbove, watchBelow:
+		current, err := strconv.ParseFloat(state, 64)
+		if err != nil {
+			return false
+		}
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		if record.GetString("condition") == watchAbove {
+			return current > threshold
+		}
+		return current < threshold
+	case watchUnavailable:
+		return state == "unavailable"
+	default:
+		return false
+	}
+}
+
+func (b *Bot) sendWatchMessage(record *core.Record, event ha.StateChangedEvent, recovery bool) error {
+	state := event.NewState
+
+	data := watchData{
+		Name:       record.GetString("name"),
+		EntityID:   record.GetString("entity_id"),
+		State:      state.State,
+		Value:      record.GetString("value"),
+		Attributes: state.Attributes,
+	}
+	if data.Name == "" {
+		data.Name = friendlyName(*state)
+	}
+	if event.OldState != nil {
+		data.Previous = event.OldState.State
+	}
+	if unit, ok := state.Attributes["unit_of_measurement"].(string); ok && unit != "" {
+		data.Unit = " " + unit
+	}
+
+	text := record.GetString("message")
+	if text == "" {
+		text = defaultWatchMessage
+	}
+	if recovery {
+		text = record.GetString("recovery_message")
+		if text == "" {
+			text = defaultRecoveryMessage
+		}
+	}
+
+	message, err := executeTemplate(record.Id, text, data)
+	if err != nil {
+		return fmt.Errorf("failed to render watch message: %w", err)
+	}
+
+	loc := b.alertsLocation(record.GetInt("thread_id"))
+
+	if recovery {
+		if alert, err := b.latestAlert(alertSourceWatch, record.Id); err == nil {
+			b.resolveAlert(alert, "recovery", false)
+		}
+		_, err = b.bot.Send(loc.recipient(), message, loc.sendOptions(nil))
+		return err
+	}
+
+	a := alert{
+		source:   alertSourceWatch,
+		key:      record.Id,
+		severity: record.GetString("severity"),
+		title:    message,
+		loc:      loc,
+		text:     message,
+	}
+	keyboard := &tele.ReplyMarkup{}
+	a.rows = []tele.Row{watchSnoozeRow(keyboard, record.Id)}
+
+	// Alerts can include a still of a camera
+	if camera := record.GetString("camera"); camera != "" {
+		photo, err := b.cameraSnapshot(camera, message)
+		if err == nil {
+			a.photo = photo
+		} else {
+			b.app.Logger().Warn("Error getting camera snapshot for watch", "error", err, "watch", record.Id, "camera", camera)
+		}
+	}
+
+	_, _, err = b.postAlert(a)
+	if errors.Is(err, errAlertMuted) {
+		return nil
+	}
+	return err
+}
+
+func watchSnoozeRow(keyboard *tele.ReplyMarkup, watchID string) tele.Row {
+	var buttons []tele.Btn
+	for _, option := range watchSnoozeOptions {
+		buttons = append(buttons, keyboard.Data(option.label, fmt.Sprintf("hw:snooze:%s:%d", watchID, option.minutes)))
+	}
+	return keyboard.Row(buttons...)
+}
+
+// handleWatchCallback handles the snooze buttons of watch alerts
+func (b *Bot) handleWatchCallback(c tele.Context) error {
+	data := strings.TrimPrefix(c.Callback().Data, "\fhw:")
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 || parts[0] != "snooze" {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	minutes, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	b.watchMu.Lock()
+	defer b.watchMu.Unlock()
+
+	record, err := b.app.FindRecordById("ha_watches", parts[1])
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Watch not found"})
+	}
+
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	record.Set("snoozed_until", until)
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error snoozing watch", "error", err, "watch", record.Id)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to snooze"})
+	}
+
+	note := fmt.Sprintf("\n\n😴 Snoozed until %s by %s", until.Format("Jan 2 15:04"), c.Sender().FirstName)
+	// The snooze row is replaced by the note, the alert buttons stay
+	msg := c.Message()
+	keyboard := filterKeyboard(msg.ReplyMarkup, "hw:")
+	var edited *tele.Message
+	if msg.Photo != nil {
+		edited, err = b.bot.EditCaption(msg, truncate(msg.Caption+note, captionLimit), keyboard)
+	} else {
+		edited, err = b.bot.Edit(msg, msg.Text+note, keyboard)
+	}
+	if err != nil {
+		b.app.Logger().Error("Error editing watch alert", "error", err)
+	} else {
+		b.saveEditedAlert(edited)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "😴 Snoozed"})
+}
+
+func friendlyName(state ha.StateResponse) string {
+	if name, ok := state.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return state.EntityID
+}
@@ -0,0 +1,332 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// healthStateKey holds a hash of the issues of the last posted health
+	// report, the keys themselves would outgrow the state value
+	healthStateKey = "ha_health"
+	// healthButtonLimit caps the number of mute buttons under a report
+	healthButtonLimit = 10
+	// healthSectionLimit caps the number of entities listed per section
+	healthSectionLimit = 15
+)
+
+// healthIgnoredDomains have no meaningful state, they are never reported
+// as unavailable
+var healthIgnoredDomains = []string{
+	"button",
+	"input_button",
+	"scene",
+	"event",
+	"notify",
+	"conversation",
+	"stt",
+	"tts",
+	"wake_word",
+}
+
+// healthIssue is a low battery or an entity stuck in an unavailable state
+type healthIssue struct {
+	entityID string
+	name     string
+	// battery is the level of low battery issues, -1 for binary sensors
+	battery float64
+	// state and since describe unavailable entities
+	state string
+	since time.Time
+}
+
+// key identifies the issue regardless of the current battery level, so
+// reports are only posted when the set of issues changes
+func (i healthIssue) key() string {
+	if i.state != "" {
+		return "unavailable:" + i.entityID
+	}
+	return "battery:" + i.entityID
+}
+
+// startHealthMonitor registers the periodic health check if
+// HOME_ASSISTANT_HEALTH_CRON is set
+func (b *Bot) startHealthMonitor() {
+	if b.haHealthCron == "" {
+		return
+	}
+
+	err := b.app.Cron().Add("ha_health", b.haHealthCron, func() {
+		if err := b.checkHealth(); err != nil {
+			b.app.Logger().Error("Error checking Home Assistant health", "error", err)
+		}
+	})
+	if err != nil {
+		b.app.Logger().Error("Error registering health check", "error", err, "cron", b.haHealthCron)
+	}
+}
+
+// checkHealth posts a report to the alerts topic when the set of issues
+// differs from the last report
+func (b *Bot) checkHealth() error {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	issues, muted, err := b.healthIssues()
+	if err != nil {
+		return err
+	}
+
+	previous, err := b.getState(healthStateKey)
+	if err != nil {
+		return err
+	}
+	current := healthHash(issues)
+	if current == previous {
+		return nil
+	}
+
+	text, keyboard := formatHealthReport(issues, muted)
+	if len(issues) == 0 {
+		text, keyboard = "✅ All Home Assistant entities are healthy again", nil
+	}
+
+	loc := b.alertsLocation(0)
+	opts := loc.sendOptions(keyboard)
+	opts.ParseMode = tele.ModeHTML
+	if _, err := b.bot.Send(loc.recipient(), text, opts); err != nil {
+		return err
+	}
+
+	return b.setState(map[string]any{healthStateKey: current})
+}
+
+// handleHealth shows the current health report on demand
+func (b *Bot) handleHealth(c tele.Context) error {
+	c.Notify(tele.Typing)
+
+	issues, muted, err := b.healthIssues()
+	if err != nil {
+		b.app.Logger().Error("Error checking Home Assistant health", "error", err)
+		return c.Reply("❌ Error getting states from Home Assistant")
+	}
+	if len(issues) == 0 {
+		text := "✅ All Home Assistant entities are healthy"
+		if muted > 0 {
+			text += fmt.Sprintf("\n🔇 %d muted", muted)
+		}
+		return c.Reply(text)
+	}
+
+	text, keyboard := formatHealthReport(issues, muted)
+	return c.Reply(text, keyboard, tele.ModeHTML)
+}
+
+// healthIssues scans all states for low batteries and entities stuck in
+// unavailable or unknown, muted entities are only counted
+func (b *Bot) healthIssues() ([]healthIssue, int, error) {
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mutedEntities := make(map[string]bool)
+	records, err := b.app.FindAllRecords("health_mutes")
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, record := range records {
+		mutedEntities[record.GetString("entity_id")] = true
+	}
+
+	now := time.Now()
+	var issues []healthIssue
+	muted := 0
+	for _, state := range states {
+		issue, ok := b.healthIssue(state, now)
+		if !ok {
+			continue
+		}
+		if mutedEntities[state.EntityID] {
+			muted++
+			continue
+		}
+		issues = append(issues, issue)
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if (issues[i].state == "") != (issues[j].state == "") {
+			return issues[i].state == ""
+		}
+		if issues[i].battery != issues[j].battery {
+			return issues[i].battery < issues[j].battery
+		}
+		return issues[i].name < issues[j].name
+	})
+	return issues, muted, nil
+}
+
+func (b *Bot) healthIssue(state ha.StateResponse, now time.Time) (healthIssue, bool) {
+	domain := entityDomain(state.EntityID)
+	issue := healthIssue{entityID: state.EntityID, name: friendlyName(state)}
+
+	if state.State == "unavailable" || state.State == "unknown" {
+		if slices.Contains(healthIgnoredDomains, domain) || now.Sub(state.LastChanged) < b.haUnavailableAfter {
+			return issue, false
+		}
+		issue.state, issue.since = state.State, state.LastChanged
+		return issue, true
+	}
+
+	if deviceClass, _ := state.Attributes["device_class"].(string); deviceClass != "battery" {
+		return issue, false
+	}
+	switch domain {
+	case "sensor":
+		level, err := strconv.ParseFloat(state.State, 64)
+		if err != nil || level >= b.haBatteryThreshold {
+			return issue, false
+		}
+		issue.battery = level
+		return issue, true
+	case "binary_sensor":
+		issue.battery = -1
+		return issue, state.State == "on"
+	}
+	return issue, false
+}
+
+// healthHash identifies the set of issues regardless of their order, no
+// issues hash to an empty string like a missing state
+func healthHash(issues []healthIssue) string {
+	if len(issues) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		keys = append(keys, issue.key())
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func formatHealthReport(issues []healthIssue, muted int) (string, *tele.ReplyMarkup) {
+	var battery, unavailable []string
+	for _, issue := range issues {
+		name := html.EscapeString(issue.name)
+		if issue.state != "" {
+			unavailable = append(unavailable, fmt.Sprintf("• %s: %s for %s", name, issue.state, formatDuration(time.Since(issue.since))))
+			continue
+		}
+		level := "low"
+		if issue.battery >= 0 {
+			level = formatNumber(issue.battery) + "%"
+		}
+		battery = append(battery, fmt.Sprintf("• %s: %s", name, level))
+	}
+
+	lines := []string{"🩺 <b>Home Assistant health</b>"}
+	if len(battery) > 0 {
+		lines = append(lines, "", fmt.Sprintf("🔋 <b>Low battery (%d)</b>", len(battery)))
+		lines = append(lines, limitLines(battery, healthSectionLimit)...)
+	}
+	if len(unavailable) > 0 {
+		lines = append(lines, "", fmt.Sprintf("⚠️ <b>Unavailable (%d)</b>", len(unavailable)))
+		lines = append(lines, limitLines(unavailable, healthSectionLimit)...)
+	}
+	if muted > 0 {
+		lines = append(lines, "", fmt.Sprintf("🔇 %d muted", muted))
+	}
+
+	keyboard := &tele.ReplyMarkup{}
+	var buttons []tele.Btn
+	for _, issue := range issues[:min(len(issues), healthButtonLimit)] {
+		buttons = append(buttons, keyboard.Data("🔇 "+truncate(issue.name, 24), "hh:mute:"+entityHash(issue.entityID)))
+	}
+	keyboard.Inline(chunkButtons(keyboard, buttons, 2)...)
+
+	return strings.Join(lines, "\n"), keyboard
+}
+
+// handleHealthCallback mutes the entity of the tapped button, entities are
+// referenced by a hash since IDs can exceed the callback data limit
+func (b *Bot) handleHealthCallback(c tele.Context) error {
+	hash, ok := strings.CutPrefix(c.Callback().Data, "\fhh:mute:")
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		b.app.Logger().Error("Error getting states", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error getting states from Home Assistant"})
+	}
+	index := slices.IndexFunc(states, func(state ha.StateResponse) bool {
+		return entityHash(state.EntityID) == hash
+	})
+	if index < 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Entity not found"})
+	}
+	state := states[index]
+
+	collection, err := b.app.FindCollectionByNameOrId("health_mutes")
+	if err != nil {
+		b.app.Logger().Error("Error finding health_mutes collection", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to mute"})
+	}
+	record := core.NewRecord(collection)
+	record.Set("entity_id", state.EntityID)
+	record.Set("muted_by", c.Sender().FirstName)
+	if err := b.app.Save(record); err != nil {
+		// The unique index fails for entities that are muted already
+		b.app.Logger().Debug("Error muting entity", "error", err, "entity_id", state.EntityID)
+	}
+
+	// The current issues without the muted entity become the last report,
+	// so muting alone doesn't produce a new one
+	if issues, _, err := b.healthIssues(); err == nil {
+		if err := b.setState(map[string]any{healthStateKey: healthHash(issues)}); err != nil {
+			b.app.Logger().Error("Error saving health state", "error", err)
+		}
+	}
+
+	msg := c.Message()
+	keyboard := &tele.ReplyMarkup{}
+	if msg.ReplyMarkup != nil {
+		for _, row := range msg.ReplyMarkup.InlineKeyboard {
+			row = slices.DeleteFunc(row, func(button tele.InlineButton) bool {
+				return button.Data == c.Callback().Data
+			})
+			if len(row) > 0 {
+				keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+			}
+		}
+	}
+	text := msg.Text + fmt.Sprintf("\n🔇 %s muted by %s", friendlyName(state), c.Sender().FirstName)
+	if _, err := b.bot.Edit(msg, text, &tele.SendOptions{Entities: msg.Entities, ReplyMarkup: keyboard}); err != nil {
+		b.app.Logger().Error("Error editing health report", "error", err)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "🔇 Muted " + friendlyName(state)})
+}
+
+// entityHash is a short stable reference to an entity for callback data
+func entityHash(entityID string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(entityID))), 36)
+}
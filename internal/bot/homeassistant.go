@@ -2,13 +2,103 @@ package bot
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
 	tele "gopkg.in/telebot.v4"
 )
 
+const (
+	// haPageSize is the number of device buttons per keyboard page
+	haPageSize = 8
+	// haCallbackLimit is the Telegram limit for callback data
+	haCallbackLimit = 64
+	// haNoArea groups devices without an area
+	haNoArea = "_"
+)
+
+// Views of the /ha keyboard
+const (
+	haViewFavorites = "f"
+	haViewAreas     = "as"
+	haViewArea      = "a"
+	haViewDomains   = "ds"
+	haViewDomain    = "d"
+)
+
+// Actions of the /ha keyboard
+const (
+	haActionView    = "v"
+	haActionDevice  = "t"
+	haActionPanel   = "p"
+	haActionCommand = "c"
+	haActionConfirm = "y"
+	haActionCancel  = "n"
+	haActionPINKey  = "k"
+)
+
+// haCallback is the callback data of the /ha keyboard, encoded as
+// "ha:<action>:<view>:<arg>:<page>:<device>:<cmd>". Every button carries the
+// view it was pressed on, so the same view can be rendered after an action.
+type haCallback struct {
+	Action string
+	View   string
+	Arg    string
+	Page   int
+	// Device is the ID of the devices record
+	Device string
+	// Cmd is the control panel command, e.g. "bri=+25"
+	Cmd string
+}
+
+func (cb haCallback) String() string {
+	return strings.Join([]string{"ha", cb.Action, cb.View, cb.Arg, strconv.Itoa(cb.Page), cb.Device, cb.Cmd}, ":")
+}
+
+func parseHACallback(data string) (haCallback, error) {
+	parts := strings.SplitN(strings.TrimPrefix(data, "\f"), ":", 7)
+	// Keyboards sent before control panels have no command part
+	if len(parts) == 6 {
+		parts = append(parts, "")
+	}
+	if len(parts) != 7 || parts[0] != "ha" {
+		return haCallback{}, fmt.Errorf("invalid callback data %q", data)
+	}
+
+	page, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return haCallback{}, fmt.Errorf("invalid page in callback data %q", data)
+	}
+
+	return haCallback{
+		Action: parts[1],
+		View:   parts[2],
+		Arg:    parts[3],
+		Page:   page,
+		Device: parts[5],
+		Cmd:    parts[6],
+	}, nil
+}
+
+// button creates a keyboard button, long area IDs might not fit into
+// the callback data, such buttons fall back to the favorites view
+func (cb haCallback) button(keyboard *tele.ReplyMarkup, text string) tele.Btn {
+	if len(cb.String())+1 > haCallbackLimit {
+		cb.View, cb.Arg, cb.Page = haViewFavorites, "", 0
+	}
+	return keyboard.Data(text, cb.String())
+}
+
 func (b *Bot) handleHomeAssistant(c tele.Context) error {
+	if c.Message().Payload == "sync" {
+		return b.handleHomeAssistantSync(c)
+	}
+
 	// Get devices from pocketbase
 	devices, err := b.getDevices()
 	if err != nil {
@@ -20,135 +110,416 @@ func (b *Bot) handleHomeAssistant(c tele.Context) error {
 		return c.Reply("📱 No devices found in database")
 	}
 
-	// Create keyboard with devices and refresh button
-	keyboard := b.createDeviceKeyboard(devices)
+	// Favorites are the landing view, areas are shown if there are none
+	view := haCallback{Action: haActionView, View: haViewFavorites}
+	if !hasFavorites(devices) {
+		view.View = haViewAreas
+	}
+
+	title, keyboard := b.renderHAView(view, devices, b.getDeviceStates())
 
-	return c.Send("🏠 Home Assistant Devices:", keyboard)
+	return c.Send(title, keyboard)
 }
 
-func (b *Bot) createDeviceKeyboard(devices []ha.Device) *tele.ReplyMarkup {
+// getDeviceStates returns current states keyed by entity ID. The keyboard
+// is still usable without states, so errors are only logged.
+func (b *Bot) getDeviceStates() map[string]ha.StateResponse {
+	states, err := b.haClient.GetStates()
+	if err != nil {
+		b.app.Logger().Warn("Error getting Home Assistant states", "error", err)
+		return nil
+	}
+
+	result := make(map[string]ha.StateResponse, len(states))
+	for _, state := range states {
+		result[state.EntityID] = state
+	}
+	return result
+}
+
+// renderHAView returns the message text and keyboard of a /ha view
+func (b *Bot) renderHAView(view haCallback, devices []ha.Device, states map[string]ha.StateResponse) (string, *tele.ReplyMarkup) {
 	keyboard := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
-	// Add device buttons
-	for _, device := range devices {
-		btn := keyboard.Data(
-			fmt.Sprintf("%s %s", getDeviceIcon(device.Type), device.Name),
-			fmt.Sprintf("ha:%s", device.EntityID),
-		)
+	var (
+		title  string
+		items  []tele.Btn
+		parent *haCallback
+	)
+
+	switch view.View {
+	case haViewAreas:
+		title = "🏠 Areas"
+		for _, area := range deviceAreas(devices) {
+			cb := haCallback{Action: haActionView, View: haViewArea, Arg: area.id}
+			items = append(items, cb.button(keyboard, fmt.Sprintf("%s (%d)", area.name, area.count)))
+		}
+	case haViewDomains:
+		title = "🧩 Domains"
+		for _, domain := range deviceDomains(devices) {
+			cb := haCallback{Action: haActionView, View: haViewDomain, Arg: domain.id}
+			items = append(items, cb.button(keyboard, fmt.Sprintf("%s %s (%d)", getDeviceIcon(domain.id), domain.name, domain.count)))
+		}
+	case haViewArea, haViewDomain, haViewFavorites:
+		var filtered []ha.Device
+		for _, device := range devices {
+			if deviceInView(device, view) {
+				filtered = append(filtered, device)
+			}
+		}
+
+		switch view.View {
+		case haViewArea:
+			title = "🏠 " + areaName(view.Arg, devices)
+			parent = &haCallback{Action: haActionView, View: haViewAreas}
+		case haViewDomain:
+			title = fmt.Sprintf("%s %s", getDeviceIcon(view.Arg), view.Arg)
+			parent = &haCallback{Action: haActionView, View: haViewDomains}
+		default:
+			title = "⭐ Favorites"
+		}
+
+		for _, device := range filtered {
+			text := fmt.Sprintf("%s %s", getDeviceIcon(device.Type), device.Name)
+			if state, ok := states[device.EntityID]; ok {
+				text = fmt.Sprintf("%s · %s", text, formatState(device.Type, state))
+			}
+			cb := haCallback{Action: haActionDevice, View: view.View, Arg: view.Arg, Page: view.Page, Device: device.ID}
+			items = append(items, cb.button(keyboard, text))
+		}
+	}
+
+	if len(items) == 0 {
+		title += "\n\n📭 Nothing here yet"
+	}
+
+	// Paginate the items
+	pages := max(1, (len(items)+haPageSize-1)/haPageSize)
+	page := min(max(view.Page, 0), pages-1)
+	start, end := page*haPageSize, min((page+1)*haPageSize, len(items))
+	for _, btn := range items[start:end] {
 		rows = append(rows, keyboard.Row(btn))
 	}
 
-	// Add refresh button as the last button
-	refreshBtn := keyboard.Data("🔄 Refresh", "ha:refresh")
-	rows = append(rows, keyboard.Row(refreshBtn))
+	if pages > 1 {
+		var nav []tele.Btn
+		if page > 0 {
+			prev := view
+			prev.Action, prev.Page, prev.Device = haActionView, page-1, ""
+			nav = append(nav, prev.button(keyboard, "◀️"))
+		}
+		current := view
+		current.Action, current.Page, current.Device = haActionView, page, ""
+		nav = append(nav, current.button(keyboard, fmt.Sprintf("%d/%d", page+1, pages)))
+		if page < pages-1 {
+			next := view
+			next.Action, next.Page, next.Device = haActionView, page+1, ""
+			nav = append(nav, next.button(keyboard, "▶️"))
+		}
+		rows = append(rows, keyboard.Row(nav...))
+	}
+
+	// Refresh re-renders the current page with fresh states
+	refresh := view
+	refresh.Action, refresh.Page, refresh.Device = haActionView, page, ""
+	bottom := []tele.Btn{refresh.button(keyboard, "🔄 Refresh")}
+	if parent != nil {
+		bottom = append([]tele.Btn{parent.button(keyboard, "⬅️ Back")}, bottom...)
+	}
+	rows = append(rows, keyboard.Row(bottom...))
+
+	// Top level views link to each other
+	if parent == nil {
+		var sections []tele.Btn
+		for _, section := range []struct {
+			view string
+			text string
+		}{
+			{haViewFavorites, "⭐ Favorites"},
+			{haViewAreas, "🏠 Areas"},
+			{haViewDomains, "🧩 Domains"},
+		} {
+			if section.view != view.View {
+				cb := haCallback{Action: haActionView, View: section.view}
+				sections = append(sections, cb.button(keyboard, section.text))
+			}
+		}
+		rows = append(rows, keyboard.Row(sections...))
+	}
 
 	keyboard.Inline(rows...)
-	return keyboard
+	return title, keyboard
 }
 
-func (b *Bot) handleHomeAssistantCallback(c tele.Context) error {
-	data := c.Callback().Data
-	entityID := strings.TrimPrefix(data, "\fha:")
+// showHAView re-reads devices and their states and edits the keyboard
+// message in place
+func (b *Bot) showHAView(c tele.Context, view haCallback) error {
+	devices, err := b.getDevices()
+	if err != nil {
+		return err
+	}
 
-	b.app.Logger().Debug("Home Assistant callback", "entity_id", entityID)
+	title, keyboard := b.renderHAView(view, devices, b.getDeviceStates())
 
-	// Handle refresh action
-	if entityID == "refresh" {
-		// Get updated devices from database
-		devices, err := b.getDevices()
-		if err != nil {
-			b.app.Logger().Error("Error getting devices for refresh", "error", err)
-			return c.Respond(&tele.CallbackResponse{Text: "❌ Error refreshing devices"})
-		}
+	return editMessage(c, title, keyboard)
+}
 
-		if len(devices) == 0 {
-			return c.Respond(&tele.CallbackResponse{Text: "📱 No devices found"})
-		}
+func (b *Bot) handleHomeAssistantCallback(c tele.Context) error {
+	cb, err := parseHACallback(c.Callback().Data)
+	if err != nil {
+		b.app.Logger().Debug("Invalid Home Assistant callback", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ This keyboard is outdated, use /ha"})
+	}
 
-		// Create new keyboard with updated devices
-		keyboard := b.createDeviceKeyboard(devices)
+	b.app.Logger().Debug("Home Assistant callback", "callback", cb.String())
 
-		// Edit the message with the new keyboard
-		err = c.Edit("🏠 Home Assistant Devices:", keyboard)
-		if err != nil {
-			b.app.Logger().Error("Error editing message", "error", err)
-			return c.Respond(&tele.CallbackResponse{Text: "❌ Error refreshing"})
+	if cb.Action == haActionView {
+		if err := b.showHAView(c, cb); err != nil {
+			b.app.Logger().Error("Error showing Home Assistant view", "error", err)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Error refreshing devices"})
 		}
-
-		return c.Respond(&tele.CallbackResponse{Text: "✅ Devices refreshed"})
+		return c.Respond()
 	}
 
 	// Get device from database to determine action
-	device, err := b.getDevice(entityID)
+	device, err := b.getDeviceByID(cb.Device)
 	if err != nil {
 		b.app.Logger().Error("Error getting device", "error", err)
 		return c.Respond(&tele.CallbackResponse{Text: "❌ Device not found"})
 	}
 
-	// Perform action based on device type
-	var action string
+	switch {
+	case cb.Action == haActionDevice && device.Type == "camera":
+		return b.handleCameraButton(c, device.EntityID)
+	case cb.Action == haActionPanel, cb.Action == haActionDevice && hasControlPanel(device.Type):
+		return b.handleDevicePanel(c, cb, device)
+	case cb.Action == haActionConfirm, cb.Action == haActionCancel:
+		return b.handleConfirmation(c, cb, device)
+	case cb.Action == haActionPINKey:
+		return b.handlePINKey(c, cb, device)
+	}
 
-	switch device.Type {
-	case "light", "switch":
-		// For lights and switches, toggle them
-		err = b.haClient.Toggle(entityID)
-		action = "toggled"
-	case "button":
-		// For buttons, press them
-		err = b.haClient.PressButton(entityID)
-		action = "pressed"
-	default:
-		// For other devices, try to toggle
-		err = b.haClient.Toggle(entityID)
-		action = "toggled"
+	if err := checkAllowedHours(device, time.Now()); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ " + err.Error(), ShowAlert: true})
 	}
 
-	if err != nil {
-		b.app.Logger().Error("Error controlling device", "error", err, "entity_id", entityID)
+	// Sensitive devices need a second tap
+	if device.Confirm || device.PINHash != "" {
+		return b.askConfirmation(c, cb, device)
+	}
+
+	return b.runDeviceAction(c, userActor(haSourceKeyboard, c.Sender()), cb, device)
+}
+
+// runDeviceAction performs the action of a device button or a control
+// panel command
+func (b *Bot) runDeviceAction(c tele.Context, by actor, cb haCallback, device *ha.Device) error {
+	if cb.Action == haActionCommand {
+		return b.handleDeviceCommand(c, by, cb, device)
+	}
+
+	service, action := directService(device.Type)
+
+	if err := b.callService(by, device, service, nil); err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("❌ Failed to control %s", device.Name)})
 	}
 
+	// Reflect the new state on the keyboard
+	cb.Action, cb.Cmd = haActionView, ""
+	if err := b.showHAView(c, cb); err != nil {
+		b.app.Logger().Error("Error updating device keyboard", "error", err)
+	}
+
 	return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("✅ %s %s", device.Name, action)})
 }
 
+// directService returns the service called by a device button without a
+// control panel and a past tense description of it
+func directService(domain string) (string, string) {
+	switch domain {
+	case "button", "input_button":
+		return "press", "pressed"
+	case "scene", "script":
+		return "turn_on", "activated"
+	default:
+		return "toggle", "toggled"
+	}
+}
+
+// callService calls a service of the device domain targeting the device
+// entity, all device controls go through it. Allowed hours of the device
+// are enforced, the code the user entered is passed to lock and alarm
+// panel services of devices with a PIN and the call is audited.
+func (b *Bot) callService(by actor, device *ha.Device, service string, data map[string]any) error {
+	if err := checkAllowedHours(device, time.Now()); err != nil {
+		b.audit(by, device.EntityID, service, data, err)
+		return err
+	}
+
+	payload := map[string]any{"entity_id": device.EntityID}
+	for key, value := range data {
+		payload[key] = value
+	}
+	if device.PINHash != "" && usesCode(device.Type) {
+		// Only a code checked against the PIN is passed on
+		if by.code == "" {
+			err := fmt.Errorf("%s needs a code", device.Name)
+			b.audit(by, device.EntityID, service, data, err)
+			return err
+		}
+		payload["code"] = by.code
+	}
+
+	b.app.Logger().Info("Calling Home Assistant service", "service", device.Type+"."+service, "entity_id", device.EntityID)
+
+	err := b.haClient.CallService(device.Type, service, payload)
+	if err != nil {
+		b.app.Logger().Error("Error controlling device", "error", err, "entity_id", device.EntityID, "service", service)
+	}
+	b.audit(by, device.EntityID, service, data, err)
+	return err
+}
+
 func (b *Bot) getDevices() ([]ha.Device, error) {
-	records, err := b.app.FindRecordsByFilter("devices", "", "-created", 0, 0)
+	records, err := b.app.FindRecordsByFilter("devices", "hidden = false", "sort,name", 0, 0)
 	if err != nil {
 		return nil, err
 	}
 
 	var devices []ha.Device
 	for _, record := range records {
-		deviceType := strings.Split(record.GetString("entity_id"), ".")[0]
-		device := ha.Device{
-			EntityID: record.GetString("entity_id"),
-			Name:     record.GetString("name"),
-			Type:     deviceType,
-		}
-		devices = append(devices, device)
+		devices = append(devices, deviceFromRecord(record))
 	}
 
 	return devices, nil
 }
 
-func (b *Bot) getDevice(entityID string) (*ha.Device, error) {
-	record, err := b.app.FindFirstRecordByFilter("devices", "entity_id = {:entityID}", map[string]any{
-		"entityID": entityID,
-	})
+func (b *Bot) getDeviceByID(id string) (*ha.Device, error) {
+	record, err := b.app.FindRecordById("devices", id)
 	if err != nil {
 		return nil, err
 	}
 
-	deviceType := strings.Split(record.GetString("entity_id"), ".")[0]
+	device := deviceFromRecord(record)
+	return &device, nil
+}
+
+// getDeviceByEntityID returns the device of the entity, entities that are
+// not in the devices collection get a device without policies
+func (b *Bot) getDeviceByEntityID(entityID string) *ha.Device {
+	record, err := b.app.FindFirstRecordByFilter("devices", "entity_id = {:entityID}", dbx.Params{"entityID": entityID})
+	if err != nil {
+		return &ha.Device{EntityID: entityID, Name: entityID, Type: entityDomain(entityID)}
+	}
+
+	device := deviceFromRecord(record)
+	return &device
+}
 
-	device := &ha.Device{
+func deviceFromRecord(record *core.Record) ha.Device {
+	return ha.Device{
+		ID:       record.Id,
 		EntityID: record.GetString("entity_id"),
 		Name:     record.GetString("name"),
-		Type:     deviceType,
+		Type:     entityDomain(record.GetString("entity_id")),
+		Area:     record.GetString("area"),
+		AreaID:   record.GetString("area_id"),
+		Favorite: record.GetBool("favorite"),
+
+		Confirm:      record.GetBool("confirm"),
+		AllowedHours: record.GetString("allowed_hours"),
+		PINHash:      record.GetString("pin"),
+	}
+}
+
+func deviceInView(device ha.Device, view haCallback) bool {
+	switch view.View {
+	case haViewFavorites:
+		return device.Favorite
+	case haViewArea:
+		return device.AreaID == view.Arg || (device.AreaID == "" && view.Arg == haNoArea)
+	case haViewDomain:
+		return device.Type == view.Arg
+	default:
+		return false
+	}
+}
+
+func hasFavorites(devices []ha.Device) bool {
+	for _, device := range devices {
+		if device.Favorite {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceGroup is an area or a domain with the number of its devices
+type deviceGroup struct {
+	id    string
+	name  string
+	count int
+}
+
+// deviceAreas groups devices by area, devices without an area come last
+func deviceAreas(devices []ha.Device) []deviceGroup {
+	groups := make(map[string]*deviceGroup)
+	for _, device := range devices {
+		id, name := device.AreaID, device.Area
+		if id == "" {
+			id, name = haNoArea, "Other"
+		}
+		if name == "" {
+			name = id
+		}
+		if groups[id] == nil {
+			groups[id] = &deviceGroup{id: id, name: name}
+		}
+		groups[id].count++
+	}
+
+	result := sortedGroups(groups)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].id != haNoArea && result[j].id == haNoArea
+	})
+	return result
+}
+
+func deviceDomains(devices []ha.Device) []deviceGroup {
+	groups := make(map[string]*deviceGroup)
+	for _, device := range devices {
+		if groups[device.Type] == nil {
+			groups[device.Type] = &deviceGroup{id: device.Type, name: device.Type}
+		}
+		groups[device.Type].count++
+	}
+	return sortedGroups(groups)
+}
+
+func sortedGroups(groups map[string]*deviceGroup) []deviceGroup {
+	result := make([]deviceGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func areaName(areaID string, devices []ha.Device) string {
+	for _, area := range deviceAreas(devices) {
+		if area.id == areaID {
+			return area.name
+		}
 	}
+	return areaID
+}
 
-	return device, nil
+// entityDomain returns the domain of the entity, e.g. "light" for "light.kitchen"
+func entityDomain(entityID string) string {
+	return strings.Split(entityID, ".")[0]
 }
 
 func getDeviceIcon(deviceType string) string {
@@ -157,9 +528,87 @@ func getDeviceIcon(deviceType string) string {
 		return "💡"
 	case "switch":
 		return "🔌"
-	case "button":
+	case "button", "input_button":
 		return "🔘"
+	case "input_boolean":
+		return "🎚️"
+	case "climate":
+		return "🌡️"
+	case "cover":
+		return "🪟"
+	case "fan":
+		return "🌀"
+	case "media_player":
+		return "📺"
+	case "lock":
+		return "🔒"
+	case "alarm_control_panel":
+		return "🚨"
+	case "scene":
+		return "🎬"
+	case "script":
+		return "📜"
+	case "automation":
+		return "🤖"
+	case "camera":
+		return "📷"
+	case "vacuum":
+		return "🧹"
 	default:
 		return "📱"
 	}
 }
+
+// formatState renders a short human readable state for a keyboard button
+func formatState(deviceType string, state ha.StateResponse) string {
+	switch state.State {
+	case "unavailable", "unknown":
+		return "⚠️ " + state.State
+	}
+
+	switch deviceType {
+	case "light":
+		if state.State == "on" {
+			if brightness, ok := state.Attributes["brightness"].(float64); ok {
+				return fmt.Sprintf("on %d%%", int(brightness/255*100+0.5))
+			}
+		}
+		return state.State
+	case "climate":
+		result := state.State
+		if current, ok := state.Attributes["current_temperature"].(float64); ok {
+			result += fmt.Sprintf(" %.1f°", current)
+		}
+		if target, ok := state.Attributes["temperature"].(float64); ok {
+			result += fmt.Sprintf(" → %.1f°", target)
+		}
+		return result
+	case "button", "scene", "input_button":
+		// The state of stateless entities is the time they were last used
+		return formatAgo(state.LastChanged)
+	}
+
+	if unit, ok := state.Attributes["unit_of_measurement"].(string); ok && unit != "" {
+		return fmt.Sprintf("%s %s", state.State, unit)
+	}
+	return state.State
+}
+
+// formatAgo renders a time relative to now, e.g. "5m ago"
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
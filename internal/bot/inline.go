@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/biozz/biozz-dev-bot/internal/gpts"
+	"github.com/biozz/biozz-dev-bot/internal/librechat"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// inlineDebounce is how long a query has to stay unchanged before
+	// it is sent to the model, Telegram sends an update on every keystroke
+	inlineDebounce       = 1500 * time.Millisecond
+	inlineMinQueryLength = 3
+	inlineTimeout        = 30 * time.Second
+	inlineCacheTime      = 60
+	inlineConvoTitle     = "Inline"
+)
+
+func (b *Bot) handleInlineQuery(c tele.Context) error {
+	query := strings.TrimSpace(c.Query().Text)
+	if len([]rune(query)) < inlineMinQueryLength {
+		return nil
+	}
+
+	if !b.debounceInlineQuery(c.Sender().ID) {
+		b.app.Logger().Debug("Skipping outdated inline query", "query", query)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inlineTimeout)
+	defer cancel()
+
+	provider := gpts.NewProvider(gpts.OpenAI, b.openAIAPIKey)
+	resp, err := provider.CreateChatCompletion(ctx, gpts.ChatCompletionRequest{
+		Model: b.convoModel,
+		Messages: []gpts.ChatCompletionMessage{
+			{
+				Role:    string(gpts.RoleSystem),
+				Content: "You are a helpful assistant. Answer in a single short message suitable for sending to a chat.",
+			},
+			{
+				Role:    string(gpts.RoleUser),
+				Content: query,
+			},
+		},
+	})
+	if err != nil {
+		b.app.Logger().Error("Inline chat completion error", "error", err, "query", query)
+		return err
+	}
+
+	answer := resp.Message.Content
+
+	if b.inlineLog {
+		go b.logInlineExchange(query, answer)
+	}
+
+	result := &tele.ArticleResult{
+		Title:       truncate(query, 64),
+		Description: truncate(answer, 128),
+		Text:        truncate(answer, 4096),
+	}
+
+	return c.Answer(&tele.QueryResponse{
+		Results:    tele.Results{result},
+		CacheTime:  inlineCacheTime,
+		IsPersonal: true,
+	})
+}
+
+// debounceInlineQuery waits for inlineDebounce and reports whether
+// no newer query from the same user has arrived in the meantime.
+func (b *Bot) debounceInlineQuery(userID int64) bool {
+	b.inlineMu.Lock()
+	b.inlineSeq[userID]++
+	seq := b.inlineSeq[userID]
+	b.inlineMu.Unlock()
+
+	time.Sleep(inlineDebounce)
+
+	b.inlineMu.Lock()
+	defer b.inlineMu.Unlock()
+	return b.inlineSeq[userID] == seq
+}
+
+// logInlineExchange stores inline questions and answers in a dedicated
+// LibreChat conversation, so they can be found later in the UI.
+func (b *Bot) logInlineExchange(question string, answer string) {
+	convoID, err := b.getState("inline_convo")
+	if err != nil {
+		return
+	}
+
+	if convoID == "" {
+		convoID, err = b.librechatClient.MongoCreateConversation(librechat.EndpointOpenAI)
+		if err != nil {
+			b.app.Logger().Error("Error creating inline conversation", "error", err)
+			return
+		}
+		b.librechatClient.MongoUpdateConversationTitle(convoID, inlineConvoTitle)
+		if err := b.setState(map[string]any{"inline_convo": convoID}); err != nil {
+			return
+		}
+	}
+
+	messages, err := b.librechatClient.MongoGetConversationMessages(convoID)
+	if err != nil {
+		b.app.Logger().Error("Error getting inline conversation messages", "error", err)
+		return
+	}
+
+	parentID := librechat.DefaultParentMessageID
+	if len(messages) > 0 {
+		parentID = messages[len(messages)-1].ID
+	}
+
+	questionID, err := b.librechatClient.MongoCreateMessage(convoID, question, parentID, true)
+	if err != nil {
+		b.app.Logger().Error("Error logging inline question", "error", err)
+		return
+	}
+	b.librechatClient.MongoCreateMessage(convoID, answer, questionID, false)
+}
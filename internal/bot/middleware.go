@@ -6,6 +6,14 @@ import (
 
 func (b *Bot) LogMessage(next tele.HandlerFunc) tele.HandlerFunc {
 	return func(c tele.Context) error {
+		if c.Query() != nil {
+			b.app.Logger().Info(
+				"New inline query",
+				"text", c.Query().Text,
+				"user_id", c.Sender().ID,
+			)
+			return next(c)
+		}
 		b.app.Logger().Info(
 			"New message",
 			"text", c.Message().Text,
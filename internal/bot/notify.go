@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// notifyEventType is fired in Home Assistant when a notification button is tapped
+	notifyEventType = "telegram_notify_action"
+	// notifyImageLimit caps the size of downloaded notification images
+	notifyImageLimit = 10 << 20
+	// notifyActionLimit keeps "\fhn:<action>" within the 64 byte callback data
+	notifyActionLimit = 60
+)
+
+var notifyHTTPClient = &http.Client{Timeout: 20 * time.Second}
+
+// notifyRequest is the body of POST /api/ha/notify
+type notifyRequest struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Topic    string         `json:"topic"`
+	ThreadID int            `json:"thread_id"`
+	ImageURL string         `json:"image_url"`
+	Silent   bool           `json:"silent"`
+	Actions  []notifyAction `json:"actions"`
+}
+
+// notifyAction is a button, action is sent back to Home Assistant
+type notifyAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+}
+
+// handleHANotify posts a notification from a Home Assistant automation,
+// the token is passed as a bearer token or as the token query parameter
+func (b *Bot) handleHANotify(e *core.RequestEvent) error {
+	if !validToken(e.Request, b.haNotifyToken) {
+		return e.UnauthorizedError("Invalid token", nil)
+	}
+
+	var req notifyRequest
+	if err := e.BindBody(&req); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if strings.TrimSpace(req.Title) == "" && strings.TrimSpace(req.Message) == "" {
+		return e.BadRequestError("Title or message is required", nil)
+	}
+	for _, action := range req.Actions {
+		if action.Action == "" || len(action.Action) > notifyActionLimit {
+			return e.BadRequestError(fmt.Sprintf("Action IDs must be 1-%d bytes long", notifyActionLimit), nil)
+		}
+	}
+
+	msg, err := b.sendNotification(req)
+	if err != nil {
+		b.app.Logger().Error("Error sending Home Assistant notification", "error", err)
+		return e.InternalServerError("Failed to send notification", nil)
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{"message_id": msg.ID})
+}
+
+// sendNotification posts the notification to the requested topic, the
+// image is attached as a photo when it can be downloaded
+func (b *Bot) sendNotification(req notifyRequest) (*tele.Message, error) {
+	loc := b.alertsLocation(req.ThreadID)
+	if req.ThreadID == 0 && req.Topic != "" {
+		if routed, ok := b.featureLocation(req.Topic); ok {
+			loc = routed
+		}
+	}
+
+	var keyboard *tele.ReplyMarkup
+	if len(req.Actions) > 0 {
+		keyboard = &tele.ReplyMarkup{}
+		buttons := make([]tele.Btn, 0, len(req.Actions))
+		for _, action := range req.Actions {
+			title := action.Title
+			if title == "" {
+				title = action.Action
+			}
+			buttons = append(buttons, keyboard.Data(title, "hn:"+action.Action))
+		}
+		keyboard.Inline(chunkButtons(keyboard, buttons, 3)...)
+	}
+
+	opts := loc.sendOptions(keyboard)
+	opts.ParseMode = tele.ModeHTML
+	opts.DisableNotification = req.Silent
+
+	if req.ImageURL != "" {
+		image, err := b.notificationImage(req.ImageURL)
+		if err == nil {
+			photo := &tele.Photo{
+				File:    tele.FromReader(bytes.NewReader(image)),
+				Caption: formatNotification(req.Title, req.Message, captionLimit),
+			}
+			return b.bot.Send(loc.recipient(), photo, opts)
+		}
+		b.app.Logger().Warn("Error getting notification image", "error", err, "url", req.ImageURL)
+	}
+
+	return b.bot.Send(loc.recipient(), formatNotification(req.Title, req.Message, messageLimit), opts)
+}
+
+// notificationImage downloads the image, paths like /api/camera_proxy/...
+// are fetched from Home Assistant with its token
+func (b *Bot) notificationImage(url string) ([]byte, error) {
+	if strings.HasPrefix(url, "/") {
+		return b.haClient.GetImage(url)
+	}
+
+	resp, err := notifyHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, notifyImageLimit))
+}
+
+// formatNotification renders the title in bold above the message, the
+// text is cut before escaping so the markup stays intact
+func formatNotification(title, message string, limit int) string {
+	title, message = strings.TrimSpace(title), strings.TrimSpace(message)
+	if title == "" {
+		return html.EscapeString(truncate(message, limit))
+	}
+	if message == "" {
+		return "<b>" + html.EscapeString(truncate(title, limit)) + "</b>"
+	}
+	title = truncate(title, limit/4)
+	message = truncate(message, limit-len([]rune(title))-2)
+	return "<b>" + html.EscapeString(title) + "</b>\n\n" + html.EscapeString(message)
+}
+
+// handleNotifyCallback fires the tapped action as a Home Assistant event
+// and replaces the buttons with the answer
+func (b *Bot) handleNotifyCallback(c tele.Context) error {
+	data := strings.TrimPrefix(c.Callback().Data, "\f")
+	action := strings.TrimPrefix(data, "hn:")
+	msg := c.Message()
+
+	title := notifyButtonTitle(msg, c.Callback().Data)
+	if title == "" {
+		title = action
+	}
+
+	sender := c.Sender()
+	err := b.haClient.FireEvent(notifyEventType, map[string]any{
+		"action":     action,
+		"title":      title,
+		"user_id":    sender.ID,
+		"user_name":  strings.TrimSpace(sender.FirstName + " " + sender.LastName),
+		"chat_id":    msg.Chat.ID,
+		"thread_id":  msg.ThreadID,
+		"message_id": msg.ID,
+	})
+	if err != nil {
+		b.app.Logger().Error("Error firing notification event", "error", err, "action", action)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to send the answer to Home Assistant", ShowAlert: true})
+	}
+
+	// Answers are final, the buttons are removed so an action fires once
+	note := fmt.Sprintf("\n\n✅ %s by %s", title, sender.FirstName)
+	if msg.Photo != nil {
+		_, err = b.bot.EditCaption(msg, truncate(msg.Caption+note, captionLimit), &tele.SendOptions{Entities: msg.CaptionEntities})
+	} else {
+		_, err = b.bot.Edit(msg, msg.Text+note, &tele.SendOptions{Entities: msg.Entities})
+	}
+	if err != nil {
+		b.app.Logger().Error("Error editing notification", "error", err)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "✅ " + title})
+}
+
+// notifyButtonTitle finds the label of the tapped button in the message keyboard
+func notifyButtonTitle(msg *tele.Message, data string) string {
+	if msg.ReplyMarkup == nil {
+		return ""
+	}
+	for _, row := range msg.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if button.Data == data {
+				return button.Text
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// messageLimit leaves room for markup within the Telegram message limit
+	messageLimit = 3800
+	// stateColumnLimit caps the width of the entity and state columns
+	stateColumnLimit = 32
+)
+
+// handleHAState shows states matching an entity ID, domain or glob as a
+// table, a single entity is shown with all of its attributes
+func (b *Bot) handleHAState(c tele.Context) error {
+	pattern := strings.TrimSpace(c.Message().Payload)
+	if pattern == "" {
+		return c.Reply("Usage: /hastate <entity_id|domain|glob>\nExample: /hastate sensor.*_temperature")
+	}
+
+	states, err := b.haClient.FindStates(pattern)
+	if err != nil {
+		b.app.Logger().Error("Error getting states", "error", err)
+		return c.Reply("❌ Error getting states from Home Assistant")
+	}
+	if len(states) == 0 {
+		return c.Reply(fmt.Sprintf("📭 No entities match %s", pattern))
+	}
+
+	if len(states) == 1 && states[0].EntityID == pattern {
+		return c.Reply(formatStateDetails(states[0]), tele.ModeHTML)
+	}
+	return c.Reply(formatStateTable(states), tele.ModeHTML)
+}
+
+// handleHATemplate renders a Jinja template in Home Assistant
+func (b *Bot) handleHATemplate(c tele.Context) error {
+	template := strings.TrimSpace(c.Message().Payload)
+	if template == "" {
+		return c.Reply("Usage: /hatpl <template>\nExample: /hatpl {{ states('sensor.outdoor_temperature') }}")
+	}
+
+	result, err := b.haClient.RenderTemplate(template)
+	if err != nil {
+		b.app.Logger().Warn("Error rendering template", "error", err)
+		return c.Reply(fmt.Sprintf("❌ %v", err))
+	}
+
+	result = strings.TrimSpace(result)
+	if result == "" {
+		return c.Reply("📭 Empty result")
+	}
+	return c.Reply(truncate(result, messageLimit))
+}
+
+func formatStateTable(states []ha.StateResponse) string {
+	rows := make([][3]string, 0, len(states))
+	var entityWidth, stateWidth int
+	for _, state := range states {
+		value := state.State
+		if unit := state.Unit(); unit != "" {
+			value += " " + unit
+		}
+		row := [3]string{
+			truncate(state.EntityID, stateColumnLimit),
+			truncate(value, stateColumnLimit),
+			strings.Join(state.KeyAttributes(), ", "),
+		}
+		entityWidth = max(entityWidth, utf8.RuneCountInString(row[0]))
+		stateWidth = max(stateWidth, utf8.RuneCountInString(row[1]))
+		rows = append(rows, row)
+	}
+
+	var lines []string
+	length := 0
+	for i, row := range rows {
+		line := strings.TrimRight(fmt.Sprintf("%s  %s  %s", pad(row[0], entityWidth), pad(row[1], stateWidth), row[2]), " ")
+		if length+len(line) > messageLimit {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(rows)-i))
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+	}
+
+	return "<pre>" + html.EscapeString(strings.Join(lines, "\n")) + "</pre>"
+}
+
+func formatStateDetails(state ha.StateResponse) string {
+	keys := make([]string, 0, len(state.Attributes))
+	for key := range state.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	value := state.State
+	if unit := state.Unit(); unit != "" {
+		value += " " + unit
+	}
+
+	lines := []string{
+		fmt.Sprintf("<b>%s</b>", html.EscapeString(friendlyName(state))),
+		fmt.Sprintf("<code>%s</code>", html.EscapeString(state.EntityID)),
+		"",
+		fmt.Sprintf("State: <b>%s</b>", html.EscapeString(value)),
+		fmt.Sprintf("Changed: %s", formatAgo(state.LastChanged)),
+	}
+	if len(keys) > 0 {
+		lines = append(lines, "")
+	}
+	length := len(strings.Join(lines, "\n"))
+	for _, key := range keys {
+		line := html.EscapeString(fmt.Sprintf("%s: %s", key, truncate(ha.FormatAttribute(key, state.Attributes[key]), 200)))
+		// Cutting the text could break the markup, so whole lines are dropped
+		if length+len(line) > messageLimit {
+			lines = append(lines, "…")
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// pad fills the text with spaces up to width runes
+func pad(text string, width int) string {
+	return text + strings.Repeat(" ", max(0, width-utf8.RuneCountInString(text)))
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/biozz/biozz-dev-bot/internal/gpts"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// maxContextURLs limits how many links from a single message are fetched
+	maxContextURLs = 3
+	fetchTimeout   = 20 * time.Second
+)
+
+// handleRead fetches the URL from the command payload or the replied-to
+// message and replies with a summary of the page.
+func (b *Bot) handleRead(c tele.Context) error {
+	url := strings.TrimSpace(c.Message().Payload)
+	if url == "" && c.Message().ReplyTo != nil {
+		if urls := messageURLs(c.Message().ReplyTo); len(urls) > 0 {
+			url = urls[0]
+		}
+	}
+	if url == "" {
+		return c.Reply("Usage: /read <url>")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+
+	c.Notify(tele.Typing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	page, err := b.fetcher.Fetch(ctx, url)
+	if err != nil {
+		b.app.Logger().Error("Error fetching URL", "error", err, "url", url)
+		return c.Reply(fmt.Sprintf("❌ Unable to fetch %s: %v", url, err))
+	}
+	if page.Text == "" {
+		return c.Reply("📭 No readable content found")
+	}
+
+	prompt, err := b.renderPrompt("read", promptData{
+		Text:  page.Text,
+		Title: page.Title,
+		URL:   page.URL,
+	})
+	if err != nil {
+		b.app.Logger().Error("Error rendering prompt", "error", err, "prompt", "read")
+		return c.Reply("❌ Unable to render prompt")
+	}
+
+	provider := gpts.NewProvider(gpts.OpenAI, b.openAIAPIKey)
+	resp, err := provider.CreateChatCompletion(
+		context.Background(),
+		gpts.ChatCompletionRequest{
+			Model: b.summaryModel,
+			Messages: []gpts.ChatCompletionMessage{
+				{
+					Role:    string(gpts.RoleUser),
+					Content: prompt,
+				},
+			},
+		},
+	)
+	if err != nil {
+		return c.Reply("chat completion error: " + err.Error())
+	}
+
+	result := resp.Message.Content
+	if page.Title != "" {
+		result = page.Title + "\n\n" + result
+	}
+	return c.Reply(truncate(result, messageLimit), &tele.SendOptions{DisableWebPagePreview: true})
+}
+
+// urlContextMessages fetches links found in the message and returns them
+// as system messages, so the model sees the page content and not just the URL.
+// Links that fail to load are skipped.
+func (b *Bot) urlContextMessages(msg *tele.Message) []gpts.ChatCompletionMessage {
+	urls := messageURLs(msg)
+	if len(urls) > maxContextURLs {
+		urls = urls[:maxContextURLs]
+	}
+
+	var messages []gpts.ChatCompletionMessage
+	for _, url := range urls {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		page, err := b.fetcher.Fetch(ctx, url)
+		cancel()
+		if err != nil {
+			b.app.Logger().Warn("Error fetching URL for context", "error", err, "url", url)
+			continue
+		}
+		if page.Text == "" {
+			continue
+		}
+
+		content := fmt.Sprintf("Content of %s", page.URL)
+		if page.Title != "" {
+			content += fmt.Sprintf(" (%s)", page.Title)
+		}
+		content += ":\n\n" + page.Text
+		if page.Truncated {
+			content += "\n\n[content truncated]"
+		}
+
+		messages = append(messages, gpts.ChatCompletionMessage{
+			Role:    string(gpts.RoleSystem),
+			Content: content,
+		})
+	}
+	return messages
+}
+
+// messageURLs returns links from the message entities, including links
+// hidden behind text.
+func messageURLs(msg *tele.Message) []string {
+	entities := msg.Entities
+	if len(entities) == 0 {
+		entities = msg.CaptionEntities
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, entity := range entities {
+		var url string
+		switch entity.Type {
+		case tele.EntityURL:
+			url = msg.EntityText(entity)
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				url = "https://" + url
+			}
+		case tele.EntityTextLink:
+			url = entity.URL
+		default:
+			continue
+		}
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
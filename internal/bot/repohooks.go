@@ -0,0 +1,420 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// repoHookBodyLimit caps the size of accepted payloads
+	repoHookBodyLimit = 5 << 20
+	// pushBurstWindow is how long pushes to a branch are collapsed into
+	// the message of the first one
+	pushBurstWindow = 3 * time.Minute
+	// pushCommitLimit caps the number of commits listed in a push message
+	pushCommitLimit = 5
+)
+
+// Events of the repo_hooks collection
+const (
+	repoEventPush        = "push"
+	repoEventPullRequest = "pull_request"
+	repoEventRelease     = "release"
+	repoEventIssues      = "issues"
+	repoEventWorkflowRun = "workflow_run"
+)
+
+type gitUser struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+func (u gitUser) String() string {
+	switch {
+	case u.Login != "":
+		return u.Login
+	case u.Username != "":
+		return u.Username
+	default:
+		return u.Name
+	}
+}
+
+type gitCommit struct {
+	ID      string  `json:"id"`
+	Message string  `json:"message"`
+	URL     string  `json:"url"`
+	Author  gitUser `json:"author"`
+}
+
+// gitEvent holds the fields used from GitHub and Gitea payloads, both
+// share the names of the fields
+type gitEvent struct {
+	Action     string      `json:"action"`
+	Ref        string      `json:"ref"`
+	Before     string      `json:"before"`
+	After      string      `json:"after"`
+	Deleted    bool        `json:"deleted"`
+	Commits    []gitCommit `json:"commits"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender      gitUser `json:"sender"`
+	PullRequest *struct {
+		Number  int     `json:"number"`
+		Title   string  `json:"title"`
+		HTMLURL string  `json:"html_url"`
+		Merged  bool    `json:"merged"`
+		User    gitUser `json:"user"`
+		Base    struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int     `json:"number"`
+		Title   string  `json:"title"`
+		HTMLURL string  `json:"html_url"`
+		User    gitUser `json:"user"`
+	} `json:"issue"`
+	Release *struct {
+		TagName    string `json:"tag_name"`
+		Name       string `json:"name"`
+		HTMLURL    string `json:"html_url"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		RunNumber  int    `json:"run_number"`
+		HeadBranch string `json:"head_branch"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// pushBurst is the message of recent pushes to a branch
+type pushBurst struct {
+	msg     *tele.Message
+	repo    string
+	repoURL string
+	branch  string
+	before  string
+	after   string
+	pushers []string
+	commits []gitCommit
+	last    time.Time
+}
+
+// handleRepoHook receives GitHub and Gitea webhooks of repositories in the
+// repo_hooks collection, the payload signature is checked with the secret
+// of the repository
+func (b *Bot) handleRepoHook(e *core.RequestEvent) error {
+	event := e.Request.Header.Get("X-Gitea-Event")
+	if event == "" {
+		event = e.Request.Header.Get("X-GitHub-Event")
+	}
+	if event == "" {
+		return e.BadRequestError("Missing X-GitHub-Event or X-Gitea-Event header", nil)
+	}
+
+	// One byte over the limit tells an oversized body from one that fits
+	body, err := io.ReadAll(io.LimitReader(e.Request.Body, repoHookBodyLimit+1))
+	if err != nil {
+		return e.BadRequestError("Failed to read the request body", err)
+	}
+	if len(body) > repoHookBodyLimit {
+		return e.Error(http.StatusRequestEntityTooLarge, "The payload is larger than 5MB", nil)
+	}
+	var payload gitEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+
+	// The signature is checked before the repository is matched, unknown
+	// repositories are indistinguishable from invalid signatures
+	records, err := b.app.FindAllRecords("repo_hooks", dbx.HashExp{"enabled": true})
+	if err != nil {
+		b.app.Logger().Error("Error getting repo hooks", "error", err)
+		return e.InternalServerError("Failed to get repositories", nil)
+	}
+	index := slices.IndexFunc(records, func(record *core.Record) bool {
+		return validGitSignature(e.Request.Header, body, record.GetString("secret")) &&
+			record.GetString("repo") == payload.Repository.FullName
+	})
+	if index < 0 {
+		return e.UnauthorizedError("Invalid signature", nil)
+	}
+	record := records[index]
+
+	if event == "ping" || !repoHookAccepts(record, event, payload) {
+		return e.JSON(http.StatusOK, map[string]any{"posted": false})
+	}
+
+	if event == repoEventPush {
+		err = b.postPush(record, payload)
+	} else if text := formatRepoEvent(event, payload); text != "" {
+		loc := b.repoLocation(record)
+		opts := loc.sendOptions(nil)
+		opts.ParseMode = tele.ModeHTML
+		opts.DisableWebPagePreview = true
+		_, err = b.bot.Send(loc.recipient(), text, opts)
+	}
+	if err != nil {
+		b.app.Logger().Error("Error posting repository event", "error", err, "repo", payload.Repository.FullName, "event", event)
+		return e.InternalServerError("Failed to post the event", nil)
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{"posted": true})
+}
+
+// validGitSignature checks the HMAC-SHA256 of the body sent by GitHub as
+// X-Hub-Signature-256 and by Gitea as X-Gitea-Signature
+func validGitSignature(header http.Header, body []byte, secret string) bool {
+	signature := strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if signature == "" {
+		signature = header.Get("X-Gitea-Signature")
+	}
+	if signature == "" || secret == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected))
+}
+
+// repoHookAccepts applies the event and branch filters of the repository,
+// no events selected means all of them and branches are globs like "release/*"
+func repoHookAccepts(record *core.Record, event string, payload gitEvent) bool {
+	if events := record.GetStringSlice("events"); len(events) > 0 && !slices.Contains(events, event) {
+		return false
+	}
+
+	var branch string
+	switch event {
+	case repoEventPush:
+		var ok bool
+		if branch, ok = strings.CutPrefix(payload.Ref, "refs/heads/"); !ok {
+			// Tags are announced by releases
+			return false
+		}
+	case repoEventPullRequest:
+		if payload.PullRequest != nil {
+			branch = payload.PullRequest.Base.Ref
+		}
+	case repoEventWorkflowRun:
+		if payload.WorkflowRun != nil {
+			branch = payload.WorkflowRun.HeadBranch
+		}
+	}
+
+	patterns := splitList(record.GetString("branches"))
+	if branch == "" || len(patterns) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, err := path.Match(pattern, branch)
+		return err == nil && matched
+	})
+}
+
+// repoLocation returns the topic of the repository, the topic routed to
+// dev or the alerts topic
+func (b *Bot) repoLocation(record *core.Record) location {
+	if threadID := record.GetInt("thread_id"); threadID != 0 {
+		return b.alertsLocation(threadID)
+	}
+	if loc, ok := b.featureLocation(FeatureDev); ok {
+		return loc
+	}
+	return b.alertsLocation(0)
+}
+
+// postPush posts the commits of a push, pushes to the same branch within
+// pushBurstWindow are added to the message of the first push
+func (b *Bot) postPush(record *core.Record, payload gitEvent) error {
+	if payload.Deleted || len(payload.Commits) == 0 {
+		return nil
+	}
+
+	b.pushMu.Lock()
+	defer b.pushMu.Unlock()
+
+	now := time.Now()
+	for key, burst := range b.pushBursts {
+		if now.Sub(burst.last) > pushBurstWindow {
+			delete(b.pushBursts, key)
+		}
+	}
+
+	branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+	key := payload.Repository.FullName + ":" + branch
+	pusher := payload.Sender.String()
+
+	if burst, ok := b.pushBursts[key]; ok {
+		burst.commits = append(burst.commits, payload.Commits...)
+		burst.after = payload.After
+		burst.last = now
+		if !slices.Contains(burst.pushers, pusher) {
+			burst.pushers = append(burst.pushers, pusher)
+		}
+		opts := &tele.SendOptions{ParseMode: tele.ModeHTML, DisableWebPagePreview: true}
+		_, err := b.bot.Edit(burst.msg, formatPush(burst), opts)
+		if err == nil {
+			return nil
+		}
+		// The message could have been deleted, a new burst is started
+		b.app.Logger().Warn("Error editing push message", "error", err, "repo", burst.repo)
+	}
+
+	burst := &pushBurst{
+		repo:    payload.Repository.FullName,
+		repoURL: payload.Repository.HTMLURL,
+		branch:  branch,
+		before:  payload.Before,
+		after:   payload.After,
+		pushers: []string{pusher},
+		commits: payload.Commits,
+		last:    now,
+	}
+	loc := b.repoLocation(record)
+	opts := loc.sendOptions(nil)
+	opts.ParseMode = tele.ModeHTML
+	opts.DisableWebPagePreview = true
+	msg, err := b.bot.Send(loc.recipient(), formatPush(burst), opts)
+	if err != nil {
+		return err
+	}
+	burst.msg = msg
+	b.pushBursts[key] = burst
+	return nil
+}
+
+// formatPush lists the newest commits of the burst with a compare link
+func formatPush(burst *pushBurst) string {
+	noun := "commits"
+	if len(burst.commits) == 1 {
+		noun = "commit"
+	}
+	lines := []string{fmt.Sprintf("📦 <b>%s</b>:%s · %d new %s by %s",
+		html.EscapeString(burst.repo), html.EscapeString(burst.branch), len(burst.commits), noun, html.EscapeString(strings.Join(burst.pushers, ", ")))}
+
+	start := max(0, len(burst.commits)-pushCommitLimit)
+	if start > 0 {
+		lines = append(lines, fmt.Sprintf("… %d earlier", start))
+	}
+	for _, commit := range burst.commits[start:] {
+		title, _, _ := strings.Cut(commit.Message, "\n")
+		lines = append(lines, fmt.Sprintf(`• <a href="%s">%s</a> %s · %s`,
+			html.EscapeString(commit.URL), html.EscapeString(shortSHA(commit.ID)), html.EscapeString(truncate(title, 80)), html.EscapeString(commit.Author.String())))
+	}
+
+	if burst.repoURL != "" && burst.before != "" && strings.Trim(burst.before, "0") != "" {
+		lines = append(lines, fmt.Sprintf(`<a href="%s/compare/%s...%s">Compare</a>`,
+			html.EscapeString(burst.repoURL), shortSHA(burst.before), shortSHA(burst.after)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatRepoEvent renders pull request, issue, release and workflow run
+// events, actions that are not worth a message render empty
+func formatRepoEvent(event string, payload gitEvent) string {
+	repo := "<b>" + html.EscapeString(payload.Repository.FullName) + "</b>"
+	link := func(url, text string) string {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+	}
+
+	switch event {
+	case repoEventPullRequest:
+		pr := payload.PullRequest
+		if pr == nil {
+			return ""
+		}
+		icon, action := "🔀", payload.Action
+		switch payload.Action {
+		case "opened", "reopened", "ready_for_review":
+		case "closed":
+			icon = "🚫"
+			if pr.Merged {
+				icon, action = "🟣", "merged"
+			}
+		default:
+			return ""
+		}
+		return fmt.Sprintf("%s %s PR #%d %s by %s\n%s", icon, repo, pr.Number, strings.ReplaceAll(action, "_", " "),
+			html.EscapeString(payload.Sender.String()), link(pr.HTMLURL, pr.Title))
+
+	case repoEventIssues:
+		issue := payload.Issue
+		if issue == nil {
+			return ""
+		}
+		icon := "🐛"
+		switch payload.Action {
+		case "opened", "reopened":
+		case "closed":
+			icon = "✔️"
+		default:
+			return ""
+		}
+		return fmt.Sprintf("%s %s issue #%d %s by %s\n%s", icon, repo, issue.Number, payload.Action,
+			html.EscapeString(payload.Sender.String()), link(issue.HTMLURL, issue.Title))
+
+	case repoEventRelease:
+		release := payload.Release
+		if release == nil || payload.Action != "published" || release.Draft {
+			return ""
+		}
+		text := fmt.Sprintf("🚀 %s released %s", repo, link(release.HTMLURL, release.TagName))
+		if release.Prerelease {
+			text += " (pre-release)"
+		}
+		if release.Name != "" && release.Name != release.TagName {
+			text += "\n" + html.EscapeString(release.Name)
+		}
+		return text
+
+	case repoEventWorkflowRun:
+		run := payload.WorkflowRun
+		if run == nil || payload.Action != "completed" {
+			return ""
+		}
+		icon := "⚠️"
+		switch run.Conclusion {
+		case "success":
+			icon = "✅"
+		case "failure", "timed_out":
+			icon = "❌"
+		case "cancelled", "skipped":
+			icon = "⚪"
+		}
+		return fmt.Sprintf("%s %s %s %s on %s", icon, repo, link(run.HTMLURL, fmt.Sprintf("%s #%d", run.Name, run.RunNumber)),
+			html.EscapeString(strings.ReplaceAll(run.Conclusion, "_", " ")), html.EscapeString(run.HeadBranch))
+	}
+
+	return ""
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidGitSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		secret string
+		want   bool
+	}{
+		{"github", http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}, "secret", true},
+		{"gitea", http.Header{"X-Gitea-Signature": {signature}}, "secret", true},
+		{"uppercase hex", http.Header{"X-Gitea-Signature": {strings.ToUpper(signature)}}, "secret", true},
+		{"wrong secret", http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}, "other", false},
+		{"missing header", http.Header{}, "secret", false},
+		{"empty secret", http.Header{"X-Gitea-Signature": {signature}}, "", false},
+		{"other body", http.Header{"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(make([]byte, sha256.Size))}}, "secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validGitSignature(tt.header, body, tt.secret); got != tt.want {
+				t.Fatalf("validGitSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
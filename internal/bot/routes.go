@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Features that can be assigned to a chat or a topic in the routes collection
+const (
+	FeatureGPT    = "gpt"
+	FeatureAlerts = "alerts"
+	FeatureDev    = "dev"
+)
+
+// location is a chat or a forum topic within a chat
+type location struct {
+	chatID   int64
+	threadID int
+}
+
+func messageLocation(msg *tele.Message) location {
+	loc := location{chatID: msg.Chat.ID}
+	if msg.TopicMessage {
+		loc.threadID = msg.ThreadID
+	}
+	return loc
+}
+
+// convoKey is the state key holding the active conversation of the location
+func (l location) convoKey() string {
+	return fmt.Sprintf("convo:%d:%d", l.chatID, l.threadID)
+}
+
+func (l location) recipient() tele.Recipient {
+	return &tele.Chat{ID: l.chatID}
+}
+
+func (l location) sendOptions(markup *tele.ReplyMarkup) *tele.SendOptions {
+	return &tele.SendOptions{ThreadID: l.threadID, ReplyMarkup: markup}
+}
+
+// hasRoute reports whether the feature is routed to the location. Routes
+// with an empty chat_id apply to the supergroup.
+func (b *Bot) hasRoute(loc location, feature string) bool {
+	filter := "feature = {:feature} && thread_id = {:thread} && chat_id = {:chat}"
+	if loc.chatID == b.supergroupID {
+		filter = "feature = {:feature} && thread_id = {:thread} && (chat_id = {:chat} || chat_id = 0)"
+	}
+
+	_, err := b.app.FindFirstRecordByFilter("routes", filter, dbx.Params{
+		"feature": feature,
+		"thread":  loc.threadID,
+		"chat":    loc.chatID,
+	})
+	if err == nil {
+		return true
+	}
+
+	// GPT_THREAD_ID is kept as a fallback for setups without routes
+	return feature == FeatureGPT &&
+		b.gptThreadID != 0 &&
+		loc.chatID == b.supergroupID &&
+		loc.threadID == int(b.gptThreadID)
+}
+
+// featureLocation returns the first location the feature is routed to
+func (b *Bot) featureLocation(feature string) (location, bool) {
+	record, err := b.app.FindFirstRecordByFilter("routes", "feature = {:feature}", dbx.Params{"feature": feature})
+	if err != nil {
+		return location{}, false
+	}
+
+	loc := location{
+		chatID:   int64(record.GetInt("chat_id")),
+		threadID: record.GetInt("thread_id"),
+	}
+	if loc.chatID == 0 {
+		loc.chatID = b.supergroupID
+	}
+	return loc, true
+}
+
+// alertsLocation returns the supergroup topic for the thread, or the
+// topic routed to alerts if the thread is not set
+func (b *Bot) alertsLocation(threadID int) location {
+	if threadID != 0 {
+		return location{chatID: b.supergroupID, threadID: threadID}
+	}
+	if loc, ok := b.featureLocation(FeatureAlerts); ok {
+		return loc
+	}
+	return location{chatID: b.supergroupID}
+}
+
+// isGPTMessage decides whether a text message should be answered by GPT:
+// it is sent to a location routed to GPT, to a private chat, or it
+// mentions or replies to the bot anywhere else.
+func (b *Bot) isGPTMessage(c tele.Context) bool {
+	msg := c.Message()
+
+	if c.Chat().Type == tele.ChatPrivate {
+		return true
+	}
+
+	if b.hasRoute(messageLocation(msg), FeatureGPT) {
+		return true
+	}
+
+	return b.isAddressedToBot(msg)
+}
+
+func (b *Bot) isAddressedToBot(msg *tele.Message) bool {
+	for _, entity := range msg.Entities {
+		if entity.Type == tele.EntityMention && strings.EqualFold(msg.EntityText(entity), "@"+b.bot.Me.Username) {
+			return true
+		}
+	}
+
+	reply := msg.ReplyTo
+	return reply != nil && reply.TopicCreated == nil && reply.Sender != nil && reply.Sender.ID == b.bot.Me.ID
+}
+
+// stripMention removes the bot username from the message text
+func (b *Bot) stripMention(text string) string {
+	mention := "@" + b.bot.Me.Username
+	if i := strings.Index(strings.ToLower(text), strings.ToLower(mention)); i >= 0 {
+		text = text[:i] + text[i+len(mention):]
+	}
+	return strings.TrimSpace(text)
+}
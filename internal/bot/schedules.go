@@ -0,0 +1,545 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Kinds of the schedules collection
+const (
+	scheduleOnce = "once"
+	scheduleCron = "cron"
+	scheduleSun  = "sun"
+)
+
+// serviceAliases are short names accepted instead of service names
+var serviceAliases = map[string]string{
+	"on":  "turn_on",
+	"off": "turn_off",
+}
+
+var (
+	sunSpecRe = regexp.MustCompile(`^(sunrise|sunset)(?:([+-])(\w+))?$`)
+	clockRe   = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+)
+
+// weekdays maps day names of /every to cron day of week values
+var weekdays = map[string]string{
+	"weekdays": "1-5",
+	"weekends": "0,6",
+	"sun":      "0",
+	"mon":      "1",
+	"tue":      "2",
+	"wed":      "3",
+	"thu":      "4",
+	"fri":      "5",
+	"sat":      "6",
+}
+
+// scheduleAction is the service call of a schedule
+type scheduleAction struct {
+	entityID string
+	service  string
+	data     map[string]any
+}
+
+// startScheduler checks the schedules collection every minute, schedules
+// are stored in the database so they survive restarts
+func (b *Bot) startScheduler() {
+	b.app.Cron().MustAdd("schedules", "* * * * *", b.runSchedules)
+}
+
+func (b *Bot) runSchedules() {
+	b.scheduleMu.Lock()
+	defer b.scheduleMu.Unlock()
+
+	records, err := b.app.FindRecordsByFilter("schedules", "enabled = true", "", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading schedules", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		if !scheduleDue(record, now) {
+			continue
+		}
+		if err := b.runSchedule(record, now); err != nil {
+			b.app.Logger().Error("Error running schedule", "error", err, "schedule", record.Id)
+		}
+	}
+}
+
+func scheduleDue(record *core.Record, now time.Time) bool {
+	switch record.GetString("kind") {
+	case scheduleCron:
+		return cronDue(record.GetString("spec"), record.GetDateTime("last_run").Time(), now)
+	default:
+		next := record.GetDateTime("next_run")
+		return !next.IsZero() && !next.Time().After(now)
+	}
+}
+
+// cronDue reports whether the cron expression matches now in local time.
+// Ticks are not exact, so a job runs at most once a minute.
+func cronDue(expr string, last, now time.Time) bool {
+	schedule, err := cron.NewSchedule(expr)
+	if err != nil {
+		return false
+	}
+	if !last.IsZero() && last.Truncate(time.Minute).Equal(now.Truncate(time.Minute)) {
+		return false
+	}
+	return schedule.IsDue(cron.NewMoment(now))
+}
+
+// runSchedule calls the service, one-off schedules are disabled afterwards
+// and sun schedules move on to the next sunrise or sunset
+func (b *Bot) runSchedule(record *core.Record, now time.Time) error {
+	action := scheduleActionFromRecord(record)
+	device := b.getDeviceByEntityID(action.entityID)
+
+	callErr := b.callService(actor{source: haSourceSchedule}, device, action.service, action.data)
+
+	record.Set("last_run", now)
+	record.Set("last_error", "")
+	if callErr != nil {
+		record.Set("last_error", callErr.Error())
+	}
+
+	switch record.GetString("kind") {
+	case scheduleOnce:
+		record.Set("enabled", false)
+	case scheduleSun:
+		next, err := b.nextSunEvent(record.GetString("spec"), now)
+		if err != nil {
+			// Try again about the same time tomorrow
+			b.app.Logger().Warn("Error getting next sun event", "error", err, "schedule", record.Id)
+			next = record.GetDateTime("next_run").Time().Add(24 * time.Hour)
+		}
+		record.Set("next_run", next)
+	}
+
+	if err := b.app.Save(record); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("⏰ %s\n✅ Done", scheduleSummary(record))
+	if callErr != nil {
+		text = fmt.Sprintf("⏰ %s\n❌ Failed: %v", scheduleSummary(record), callErr)
+	}
+	loc := location{chatID: int64(record.GetInt("chat_id")), threadID: record.GetInt("thread_id")}
+	if loc.chatID == 0 {
+		loc = b.alertsLocation(0)
+	}
+	_, err := b.bot.Send(loc.recipient(), text, loc.sendOptions(nil))
+	return err
+}
+
+// handleIn schedules an action after a delay, e.g. /in 2h climate.heater off
+func (b *Bot) handleIn(c tele.Context) error {
+	args := splitArgs(c.Message().Payload)
+	if len(args) < 3 {
+		return c.Reply("Usage: /in <duration> <entity_id> <service> [key=value...]\nExample: /in 2h climate.heater off")
+	}
+
+	delay, err := parseDelay(args[0])
+	if err != nil || delay <= 0 {
+		return c.Reply(fmt.Sprintf("❌ Invalid duration %q, use e.g. 30m, 2h or 1d", args[0]))
+	}
+
+	return b.createSchedule(c, scheduleOnce, "", time.Now().Add(delay), args[1:])
+}
+
+// handleAt schedules an action at a time, e.g. /at 22:30 light.porch off
+// or /at 2026-01-02 08:00 switch.boiler on
+func (b *Bot) handleAt(c tele.Context) error {
+	args := splitArgs(c.Message().Payload)
+	usage := "Usage: /at [YYYY-MM-DD] <HH:MM> <entity_id> <service> [key=value...]\nExample: /at 22:30 light.porch off"
+	if len(args) < 3 {
+		return c.Reply(usage)
+	}
+
+	now := time.Now()
+	var at time.Time
+	if day, err := time.ParseInLocation("2006-01-02 15:04", args[0]+" "+args[1], time.Local); err == nil {
+		at, args = day, args[2:]
+	} else {
+		hour, minute, err := parseClock(args[0])
+		if err != nil {
+			return c.Reply(usage)
+		}
+		at = time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return c.Reply(usage)
+	}
+	if !at.After(now) {
+		return c.Reply("❌ This time has already passed")
+	}
+
+	return b.createSchedule(c, scheduleOnce, "", at, args)
+}
+
+// handleEvery schedules a recurring action, e.g. /every sunset light.porch on
+func (b *Bot) handleEvery(c tele.Context) error {
+	args := splitArgs(c.Message().Payload)
+	usage := strings.Join([]string{
+		"Usage: /every <when> <entity_id> <service> [key=value...]",
+		"When: sunset, sunrise-30m, 07:30, mon,fri 07:30, weekdays 07:30 or a quoted cron expression",
+		"Example: /every sunset light.porch on",
+	}, "\n")
+	if len(args) < 3 {
+		return c.Reply(usage)
+	}
+
+	if sunSpecRe.MatchString(args[0]) {
+		next, err := b.nextSunEvent(args[0], time.Now())
+		if err != nil {
+			b.app.Logger().Error("Error getting next sun event", "error", err)
+			return c.Reply(fmt.Sprintf("❌ Error getting %s time: %v", args[0], err))
+		}
+		return b.createSchedule(c, scheduleSun, args[0], next, args[1:])
+	}
+
+	expr, rest, err := parseRecurrence(args)
+	if err != nil {
+		return c.Reply(fmt.Sprintf("❌ %v\n\n%s", err, usage))
+	}
+	if len(rest) < 2 {
+		return c.Reply(usage)
+	}
+	return b.createSchedule(c, scheduleCron, expr, time.Time{}, rest)
+}
+
+func (b *Bot) createSchedule(c tele.Context, kind, spec string, next time.Time, args []string) error {
+	action, err := parseScheduleAction(args)
+	if err != nil {
+		return c.Reply(fmt.Sprintf("❌ %v", err))
+	}
+	// Nobody is around to enter the code when the schedule runs
+	if device := b.getDeviceByEntityID(action.entityID); device.PINHash != "" && usesCode(device.Type) {
+		return c.Reply(fmt.Sprintf("🔒 %s needs a code and can't be scheduled", device.Name))
+	}
+
+	collection, err := b.app.FindCollectionByNameOrId("schedules")
+	if err != nil {
+		b.app.Logger().Error("Error finding schedules collection", "error", err)
+		return c.Reply("❌ Error saving schedule")
+	}
+
+	loc := messageLocation(c.Message())
+	record := core.NewRecord(collection)
+	record.Set("entity_id", action.entityID)
+	record.Set("service", action.service)
+	record.Set("data", action.data)
+	record.Set("kind", kind)
+	record.Set("spec", spec)
+	record.Set("enabled", true)
+	record.Set("chat_id", loc.chatID)
+	record.Set("thread_id", loc.threadID)
+	if !next.IsZero() {
+		record.Set("next_run", next)
+	}
+
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving schedule", "error", err)
+		return c.Reply("❌ Error saving schedule")
+	}
+
+	return c.Reply("✅ Scheduled " + scheduleSummary(record))
+}
+
+func (b *Bot) handleSchedules(c tele.Context) error {
+	title, keyboard, err := b.renderSchedules(0)
+	if err != nil {
+		b.app.Logger().Error("Error loading schedules", "error", err)
+		return c.Reply("❌ Error loading schedules")
+	}
+	return c.Send(title, keyboard)
+}
+
+// renderSchedules lists active schedules with a cancel button for each
+func (b *Bot) renderSchedules(page int) (string, *tele.ReplyMarkup, error) {
+	records, err := b.app.FindRecordsByFilter("schedules", "enabled = true", "created", 0, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyboard := &tele.ReplyMarkup{}
+	if len(records) == 0 {
+		keyboard.Inline()
+		return "⏰ Schedules\n\n📭 Nothing scheduled", keyboard, nil
+	}
+
+	pages := (len(records) + haPageSize - 1) / haPageSize
+	page = min(max(page, 0), pages-1)
+	start, end := page*haPageSize, min((page+1)*haPageSize, len(records))
+
+	lines := []string{"⏰ Schedules", ""}
+	var rows []tele.Row
+	for i, record := range records[start:end] {
+		n := start + i + 1
+		line := fmt.Sprintf("%d. %s", n, scheduleSummary(record))
+		if lastError := record.GetString("last_error"); lastError != "" {
+			line += "\n    ⚠️ " + truncate(lastError, 100)
+		}
+		lines = append(lines, line)
+		rows = append(rows, keyboard.Row(keyboard.Data(
+			fmt.Sprintf("✖ %d. %s", n, truncate(record.GetString("entity_id"), 40)),
+			fmt.Sprintf("sc:cancel:%d:%s", page, record.Id),
+		)))
+	}
+
+	var nav []tele.Btn
+	if page > 0 {
+		nav = append(nav, keyboard.Data("◀️", fmt.Sprintf("sc:list:%d:", page-1)))
+	}
+	nav = append(nav, keyboard.Data("🔄 Refresh", fmt.Sprintf("sc:list:%d:", page)))
+	if page < pages-1 {
+		nav = append(nav, keyboard.Data("▶️", fmt.Sprintf("sc:list:%d:", page+1)))
+	}
+	rows = append(rows, keyboard.Row(nav...))
+
+	keyboard.Inline(rows...)
+	return strings.Join(lines, "\n"), keyboard, nil
+}
+
+// handleScheduleCallback handles the list and cancel buttons of /schedules,
+// the callback data is "sc:<action>:<page>:<id>"
+func (b *Bot) handleScheduleCallback(c tele.Context) error {
+	parts := strings.Split(strings.TrimPrefix(c.Callback().Data, "\fsc:"), ":")
+	if len(parts) != 3 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+	}
+	page, _ := strconv.Atoi(parts[1])
+
+	var notice string
+	if parts[0] == "cancel" {
+		b.scheduleMu.Lock()
+		record, err := b.app.FindRecordById("schedules", parts[2])
+		if err == nil {
+			err = b.app.Delete(record)
+		}
+		b.scheduleMu.Unlock()
+		if err != nil {
+			b.app.Logger().Error("Error cancelling schedule", "error", err, "schedule", parts[2])
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to cancel schedule"})
+		}
+		notice = "✖ Cancelled"
+	}
+
+	title, keyboard, err := b.renderSchedules(page)
+	if err != nil {
+		b.app.Logger().Error("Error loading schedules", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error loading schedules"})
+	}
+	if err := editMessage(c, title, keyboard); err != nil {
+		b.app.Logger().Error("Error editing schedules", "error", err)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: notice})
+}
+
+// nextSunEvent returns the next sunrise or sunset with the offset of the
+// spec applied, e.g. "sunset-30m", based on the sun.sun entity
+func (b *Bot) nextSunEvent(spec string, after time.Time) (time.Time, error) {
+	match := sunSpecRe.FindStringSubmatch(spec)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid sun event %q", spec)
+	}
+
+	var offset time.Duration
+	if match[3] != "" {
+		d, err := parseDelay(match[3])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid offset %q", match[3])
+		}
+		offset = d
+		if match[2] == "-" {
+			offset = -offset
+		}
+	}
+
+	state, err := b.haClient.GetState("sun.sun")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	attribute := "next_rising"
+	if match[1] == "sunset" {
+		attribute = "next_setting"
+	}
+	value, _ := state.Attributes[attribute].(string)
+	event, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sun.sun has no %s", attribute)
+	}
+
+	// With a negative offset the next event might be already due
+	next := event.Add(offset).Local()
+	for !next.After(after) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next, nil
+}
+
+func scheduleActionFromRecord(record *core.Record) scheduleAction {
+	action := scheduleAction{
+		entityID: record.GetString("entity_id"),
+		service:  record.GetString("service"),
+	}
+	if err := record.UnmarshalJSONField("data", &action.data); err != nil {
+		action.data = nil
+	}
+	return action
+}
+
+func parseScheduleAction(args []string) (scheduleAction, error) {
+	if len(args) < 2 {
+		return scheduleAction{}, errors.New("entity and service are required")
+	}
+
+	action := scheduleAction{entityID: args[0], service: args[1]}
+	if !strings.Contains(action.entityID, ".") {
+		return scheduleAction{}, fmt.Errorf("invalid entity %q, use e.g. light.porch", action.entityID)
+	}
+	if alias, ok := serviceAliases[action.service]; ok {
+		action.service = alias
+	}
+
+	for _, arg := range args[2:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return scheduleAction{}, fmt.Errorf("invalid service data %q, use key=value", arg)
+		}
+		if action.data == nil {
+			action.data = make(map[string]any)
+		}
+		action.data[key] = parseScriptValue(value)
+	}
+
+	return action, nil
+}
+
+// parseRecurrence turns the leading arguments of /every into a cron
+// expression and returns the remaining arguments
+func parseRecurrence(args []string) (string, []string, error) {
+	// A quoted cron expression is a single argument
+	if strings.Count(args[0], " ") == 4 {
+		if _, err := cron.NewSchedule(args[0]); err != nil {
+			return "", nil, fmt.Errorf("invalid cron expression: %v", err)
+		}
+		return args[0], args[1:], nil
+	}
+
+	days := "*"
+	if !clockRe.MatchString(args[0]) {
+		var values []string
+		for _, day := range strings.Split(strings.ToLower(args[0]), ",") {
+			value, ok := weekdays[day]
+			if !ok {
+				return "", nil, fmt.Errorf("unknown day %q", day)
+			}
+			values = append(values, value)
+		}
+		days = strings.Join(values, ",")
+		args = args[1:]
+		if len(args) == 0 {
+			return "", nil, errors.New("time is required")
+		}
+	}
+
+	hour, minute, err := parseClock(args[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%d %d * * %s", minute, hour, days), args[1:], nil
+}
+
+func parseClock(value string) (int, int, error) {
+	match := clockRe.FindStringSubmatch(value)
+	if match == nil {
+		return 0, 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	hour, _ := strconv.Atoi(match[1])
+	minute, _ := strconv.Atoi(match[2])
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return hour, minute, nil
+}
+
+// parseDelay parses Go durations with an additional "d" unit for days
+func parseDelay(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// splitArgs splits by whitespace keeping double quoted parts together
+func splitArgs(text string) []string {
+	var (
+		args   []string
+		quoted bool
+		buf    strings.Builder
+	)
+	flush := func() {
+		if buf.Len() > 0 {
+			args = append(args, buf.String())
+			buf.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			if !quoted {
+				// Keep empty quoted arguments out
+				flush()
+			}
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	flush()
+	return args
+}
+
+func scheduleSummary(record *core.Record) string {
+	action := scheduleActionFromRecord(record)
+	what := fmt.Sprintf("%s %s", action.entityID, action.service)
+	for key, value := range action.data {
+		what += fmt.Sprintf(" %s=%v", key, value)
+	}
+
+	switch record.GetString("kind") {
+	case scheduleCron:
+		return fmt.Sprintf("🔁 %s · %s", record.GetString("spec"), what)
+	case scheduleSun:
+		next := record.GetDateTime("next_run").Time().Local()
+		return fmt.Sprintf("🌇 %s (next %s) · %s", record.GetString("spec"), next.Format("Jan 2 15:04"), what)
+	default:
+		next := record.GetDateTime("next_run").Time().Local()
+		return fmt.Sprintf("🕒 %s · %s", next.Format("Jan 2 15:04"), what)
+	}
+}
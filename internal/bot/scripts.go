@@ -0,0 +1,491 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Actions of the /scene, /script, /automations and /cam keyboards
+const (
+	hsActionScenes      = "sl"
+	hsActionScene       = "s"
+	hsActionScripts     = "rl"
+	hsActionScript      = "r"
+	hsActionAutomations = "al"
+	hsActionAutomation  = "a"
+	hsActionEnable      = "ae"
+	hsActionTrigger     = "at"
+	hsActionCancel      = "x"
+	hsActionCameras     = "cl"
+	hsActionCamera      = "c"
+)
+
+// hsCallback is the callback data of the scene, script, automation and
+// camera keyboards, encoded as "hs:<action>:<page>:<entity_id>"
+type hsCallback struct {
+	Action   string
+	Page     int
+	EntityID string
+}
+
+func (cb hsCallback) String() string {
+	return strings.Join([]string{"hs", cb.Action, strconv.Itoa(cb.Page), cb.EntityID}, ":")
+}
+
+func (cb hsCallback) fits() bool {
+	return len(cb.String())+1 <= haCallbackLimit
+}
+
+func parseHSCallback(data string) (hsCallback, error) {
+	parts := strings.SplitN(strings.TrimPrefix(data, "\f"), ":", 4)
+	if len(parts) != 4 || parts[0] != "hs" {
+		return hsCallback{}, fmt.Errorf("invalid callback data %q", data)
+	}
+
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return hsCallback{}, fmt.Errorf("invalid page in callback data %q", data)
+	}
+
+	return hsCallback{Action: parts[1], Page: page, EntityID: parts[3]}, nil
+}
+
+// scriptDialog collects script variables field by field, it is kept in
+// the state collection per chat and topic
+type scriptDialog struct {
+	EntityID  string         `json:"entity_id"`
+	Name      string         `json:"name"`
+	Fields    []scriptField  `json:"fields"`
+	Variables map[string]any `json:"variables"`
+}
+
+type scriptField struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+	Required    bool   `json:"required"`
+}
+
+func (l location) scriptDialogKey() string {
+	return fmt.Sprintf("script_dialog:%d:%d", l.chatID, l.threadID)
+}
+
+func (b *Bot) handleScenes(c tele.Context) error {
+	return b.sendEntityList(c, hsActionScenes)
+}
+
+func (b *Bot) handleScripts(c tele.Context) error {
+	return b.sendEntityList(c, hsActionScripts)
+}
+
+func (b *Bot) handleAutomations(c tele.Context) error {
+	return b.sendEntityList(c, hsActionAutomations)
+}
+
+func (b *Bot) sendEntityList(c tele.Context, listAction string) error {
+	title, keyboard, err := b.renderEntityList(hsCallback{Action: listAction})
+	if err != nil {
+		b.app.Logger().Error("Error getting Home Assistant entities", "error", err)
+		return c.Reply("❌ Error getting entities from Home Assistant")
+	}
+	return c.Send(title, keyboard)
+}
+
+// renderEntityList returns a paginated list of scenes, scripts, automations or cameras
+func (b *Bot) renderEntityList(view hsCallback) (string, *tele.ReplyMarkup, error) {
+	var (
+		domain, title, action string
+	)
+	switch view.Action {
+	case hsActionScenes:
+		domain, title, action = "scene", "🎬 Scenes", hsActionScene
+	case hsActionScripts:
+		domain, title, action = "script", "📜 Scripts", hsActionScript
+	case hsActionCameras:
+		domain, title, action = "camera", "📷 Cameras", hsActionCamera
+	default:
+		domain, title, action = "automation", "🤖 Automations", hsActionAutomation
+	}
+
+	states, err := b.haClient.GetDomainStates(domain)
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return friendlyName(states[i]) < friendlyName(states[j])
+	})
+
+	keyboard := &tele.ReplyMarkup{}
+	var items []tele.Btn
+	for _, state := range states {
+		cb := hsCallback{Action: action, Page: view.Page, EntityID: state.EntityID}
+		if !cb.fits() {
+			b.app.Logger().Warn("Entity ID is too long for a button", "entity_id", state.EntityID)
+			continue
+		}
+
+		text := fmt.Sprintf("%s %s", getDeviceIcon(domain), friendlyName(state))
+		if domain == "automation" {
+			text = fmt.Sprintf("%s · %s", text, automationStatus(state))
+		}
+		items = append(items, keyboard.Data(text, cb.String()))
+	}
+
+	if len(items) == 0 {
+		title += "\n\n📭 Nothing here yet"
+	}
+
+	pages := max(1, (len(items)+haPageSize-1)/haPageSize)
+	page := min(max(view.Page, 0), pages-1)
+	var rows []tele.Row
+	for _, btn := range items[page*haPageSize : min((page+1)*haPageSize, len(items))] {
+		rows = append(rows, keyboard.Row(btn))
+	}
+
+	nav := func(page int, text string) tele.Btn {
+		return keyboard.Data(text, hsCallback{Action: view.Action, Page: page}.String())
+	}
+	var bottom []tele.Btn
+	if page > 0 {
+		bottom = append(bottom, nav(page-1, "◀️"))
+	}
+	bottom = append(bottom, nav(page, "🔄 Refresh"))
+	if page < pages-1 {
+		bottom = append(bottom, nav(page+1, "▶️"))
+	}
+	rows = append(rows, keyboard.Row(bottom...))
+
+	keyboard.Inline(rows...)
+	return title, keyboard, nil
+}
+
+func (b *Bot) handleScriptsCallback(c tele.Context) error {
+	cb, err := parseHSCallback(c.Callback().Data)
+	if err != nil {
+		b.app.Logger().Debug("Invalid scripts callback", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ This keyboard is outdated"})
+	}
+
+	switch cb.Action {
+	case hsActionScenes, hsActionScripts, hsActionAutomations, hsActionCameras:
+		title, keyboard, err := b.renderEntityList(cb)
+		if err != nil {
+			b.app.Logger().Error("Error getting Home Assistant entities", "error", err)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Error refreshing entities"})
+		}
+		if err := editMessage(c, title, keyboard); err != nil {
+			b.app.Logger().Error("Error editing entity list", "error", err)
+		}
+		return c.Respond()
+
+	case hsActionScene:
+		device := b.getDeviceByEntityID(cb.EntityID)
+		back := hsCallback{Action: hsActionScenes, Page: cb.Page}
+		return b.guardEntityAction(c, device, "Activate", back, func(c tele.Context) error {
+			if err := b.callService(userActor(haSourceScene, c.Sender()), device, "turn_on", nil); err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to activate scene"})
+			}
+			return c.Respond(&tele.CallbackResponse{Text: "✅ Scene activated"})
+		})
+
+	case hsActionScript:
+		device := b.getDeviceByEntityID(cb.EntityID)
+		back := hsCallback{Action: hsActionScripts, Page: cb.Page}
+		return b.guardEntityAction(c, device, "Run", back, func(c tele.Context) error {
+			return b.startScript(c, device)
+		})
+
+	case hsActionCamera:
+		return b.handleCameraButton(c, cb.EntityID)
+
+	case hsActionAutomation:
+		return b.showAutomation(c, cb, "")
+
+	case hsActionEnable:
+		state, err := b.haClient.GetState(cb.EntityID)
+		if err != nil {
+			b.app.Logger().Error("Error getting automation state", "error", err, "entity_id", cb.EntityID)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Automation not found"})
+		}
+		service, description, notice := "turn_on", "Enable", "✅ Enabled"
+		if state.State == "on" {
+			service, description, notice = "turn_off", "Disable", "⏸ Disabled"
+		}
+		device := b.getDeviceByEntityID(cb.EntityID)
+		back := hsCallback{Action: hsActionAutomation, Page: cb.Page, EntityID: cb.EntityID}
+		return b.guardEntityAction(c, device, description, back, func(c tele.Context) error {
+			if err := b.callService(userActor(haSourceAutomation, c.Sender()), device, service, nil); err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to switch automation"})
+			}
+			return b.showAutomation(c, back, notice)
+		})
+
+	case hsActionTrigger:
+		device := b.getDeviceByEntityID(cb.EntityID)
+		back := hsCallback{Action: hsActionAutomation, Page: cb.Page, EntityID: cb.EntityID}
+		return b.guardEntityAction(c, device, "Trigger", back, func(c tele.Context) error {
+			// Triggering runs the actions of the automation, skipping its conditions
+			err := b.callService(userActor(haSourceAutomation, c.Sender()), device, "trigger", map[string]any{"skip_condition": true})
+			if err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to trigger automation"})
+			}
+			return b.showAutomation(c, back, "⚡ Triggered")
+		})
+
+	case hsActionCancel:
+		if err := b.setState(map[string]any{messageLocation(c.Message()).scriptDialogKey(): ""}); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to cancel"})
+		}
+		if err := c.Edit(c.Message().Text + "\n\n❌ Cancelled"); err != nil {
+			b.app.Logger().Error("Error editing script dialog", "error", err)
+		}
+		return c.Respond()
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "❌ Unknown action"})
+}
+
+// showAutomation edits the message into the automation panel
+func (b *Bot) showAutomation(c tele.Context, cb hsCallback, notice string) error {
+	if err := b.showEntityView(c, cb); err != nil {
+		b.app.Logger().Error("Error showing automation panel", "error", err, "entity_id", cb.EntityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Automation not found"})
+	}
+
+	if notice == "" {
+		return c.Respond()
+	}
+	return c.Respond(&tele.CallbackResponse{Text: notice})
+}
+
+// showEntityView edits the message into a list of the scene, script,
+// automation and camera keyboards or into an automation panel
+func (b *Bot) showEntityView(c tele.Context, cb hsCallback) error {
+	if cb.Action != hsActionAutomation {
+		title, keyboard, err := b.renderEntityList(cb)
+		if err != nil {
+			return err
+		}
+		return editMessage(c, title, keyboard)
+	}
+
+	state, err := b.haClient.GetState(cb.EntityID)
+	if err != nil {
+		return err
+	}
+
+	keyboard := &tele.ReplyMarkup{}
+	button := func(text, action string) tele.Btn {
+		return keyboard.Data(text, hsCallback{Action: action, Page: cb.Page, EntityID: cb.EntityID}.String())
+	}
+
+	enable := "⏸ Disable"
+	if state.State != "on" {
+		enable = "▶️ Enable"
+	}
+	keyboard.Inline(
+		keyboard.Row(button(enable, hsActionEnable), button("⚡ Trigger", hsActionTrigger)),
+		keyboard.Row(button("⬅️ Back", hsActionAutomations), button("🔄 Refresh", hsActionAutomation)),
+	)
+
+	return editMessage(c, automationDetails(*state), keyboard)
+}
+
+func automationDetails(state ha.StateResponse) string {
+	return strings.Join([]string{
+		fmt.Sprintf("%s %s", getDeviceIcon("automation"), friendlyName(state)),
+		"",
+		"Status: " + automationStatus(state),
+		"Last triggered: " + formatAgo(lastTriggered(state)),
+	}, "\n")
+}
+
+func automationStatus(state ha.StateResponse) string {
+	switch state.State {
+	case "on":
+		return "✅ enabled"
+	case "off":
+		return "⏸ disabled"
+	default:
+		return "⚠️ " + state.State
+	}
+}
+
+func lastTriggered(state ha.StateResponse) time.Time {
+	value, _ := state.Attributes["last_triggered"].(string)
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// startScript runs scripts without fields right away, scripts with fields
+// start a dialog asking for the variables one by one
+func (b *Bot) startScript(c tele.Context, device *ha.Device) error {
+	entityID := device.EntityID
+	state, err := b.haClient.GetState(entityID)
+	if err != nil {
+		b.app.Logger().Error("Error getting script state", "error", err, "entity_id", entityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Script not found"})
+	}
+
+	fields, err := b.haClient.GetScriptFields(entityID)
+	if err != nil {
+		b.app.Logger().Error("Error getting script fields", "error", err, "entity_id", entityID)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Error getting script fields"})
+	}
+
+	if len(fields) == 0 {
+		if err := b.callService(userActor(haSourceScript, c.Sender()), device, "turn_on", nil); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to run script"})
+		}
+		return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("✅ %s started", friendlyName(*state))})
+	}
+
+	dialog := scriptDialog{
+		EntityID:  entityID,
+		Name:      friendlyName(*state),
+		Fields:    sortScriptFields(fields),
+		Variables: make(map[string]any),
+	}
+	if err := b.saveScriptDialog(messageLocation(c.Message()), &dialog); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Failed to start script dialog"})
+	}
+
+	if err := c.Send(scriptFieldPrompt(dialog), scriptCancelKeyboard()); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// handleScriptDialog consumes the text as the value of the current script
+// field, it reports false if there is no dialog in the location
+func (b *Bot) handleScriptDialog(c tele.Context) (bool, error) {
+	loc := messageLocation(c.Message())
+
+	value, err := b.getState(loc.scriptDialogKey())
+	if err != nil || value == "" {
+		return false, err
+	}
+
+	var dialog scriptDialog
+	if err := json.Unmarshal([]byte(value), &dialog); err != nil || len(dialog.Fields) == 0 {
+		b.app.Logger().Warn("Dropping invalid script dialog", "error", err)
+		return false, b.setState(map[string]any{loc.scriptDialogKey(): ""})
+	}
+
+	field := dialog.Fields[0]
+	text := strings.TrimSpace(c.Text())
+	if text == "-" {
+		if field.Required {
+			return true, c.Reply(fmt.Sprintf("❌ %s is required", field.Name), scriptCancelKeyboard())
+		}
+	} else {
+		dialog.Variables[field.Key] = parseScriptValue(text)
+	}
+	dialog.Fields = dialog.Fields[1:]
+
+	if len(dialog.Fields) > 0 {
+		if err := b.saveScriptDialog(loc, &dialog); err != nil {
+			return true, c.Reply("❌ Failed to save script dialog")
+		}
+		return true, c.Send(scriptFieldPrompt(dialog), scriptCancelKeyboard())
+	}
+
+	if err := b.setState(map[string]any{loc.scriptDialogKey(): ""}); err != nil {
+		return true, c.Reply("❌ Failed to finish script dialog")
+	}
+
+	// The script was confirmed when the dialog started, allowed hours are
+	// checked again by callService
+	var data map[string]any
+	if len(dialog.Variables) > 0 {
+		data = map[string]any{"variables": dialog.Variables}
+	}
+	device := b.getDeviceByEntityID(dialog.EntityID)
+	if err := b.callService(userActor(haSourceScript, c.Sender()), device, "turn_on", data); err != nil {
+		return true, c.Reply(fmt.Sprintf("❌ Failed to run %s: %v", dialog.Name, err))
+	}
+
+	return true, c.Reply(fmt.Sprintf("✅ %s started", dialog.Name))
+}
+
+func (b *Bot) saveScriptDialog(loc location, dialog *scriptDialog) error {
+	data, err := json.Marshal(dialog)
+	if err != nil {
+		return err
+	}
+	return b.setState(map[string]any{loc.scriptDialogKey(): string(data)})
+}
+
+// sortScriptFields puts required fields first, the order of fields is
+// not preserved by the services API
+func sortScriptFields(fields map[string]ha.ServiceField) []scriptField {
+	var result []scriptField
+	for key, field := range fields {
+		name := field.Name
+		if name == "" {
+			name = key
+		}
+		var example string
+		if field.Example != nil {
+			example = fmt.Sprint(field.Example)
+		}
+		result = append(result, scriptField{
+			Key:         key,
+			Name:        name,
+			Description: field.Description,
+			Example:     example,
+			Required:    field.Required,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Required != result[j].Required {
+			return result[i].Required
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func scriptFieldPrompt(dialog scriptDialog) string {
+	field := dialog.Fields[0]
+
+	lines := []string{fmt.Sprintf("📜 %s", dialog.Name), "", fmt.Sprintf("Enter %s:", field.Name)}
+	if field.Description != "" {
+		lines = append(lines, field.Description)
+	}
+	if field.Example != "" {
+		lines = append(lines, "Example: "+field.Example)
+	}
+	if !field.Required {
+		lines = append(lines, "Send - to skip")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func scriptCancelKeyboard() *tele.ReplyMarkup {
+	keyboard := &tele.ReplyMarkup{}
+	keyboard.Inline(keyboard.Row(keyboard.Data("❌ Cancel", hsCallback{Action: hsActionCancel}.String())))
+	return keyboard
+}
+
+// parseScriptValue keeps numbers, booleans, lists and objects typed,
+// anything else is passed as a string
+func parseScriptValue(text string) any {
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err == nil {
+		return value
+	}
+	return text
+}
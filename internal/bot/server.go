@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterRoutes adds the bot endpoints to the PocketBase router
+func (b *Bot) RegisterRoutes(se *core.ServeEvent) {
+	if b.haNotifyToken != "" {
+		se.Router.POST("/api/ha/notify", b.handleHANotify)
+	}
+	if b.alertmanagerToken != "" {
+		se.Router.POST("/api/alertmanager", b.handleAlertmanager)
+	}
+	se.Router.POST("/api/webhooks/{source}", b.handleWebhook)
+	se.Router.POST("/api/git", b.handleRepoHook)
+}
+
+// validToken checks the bearer token or the token query parameter of the
+// request, an empty expected token never matches
+func validToken(r *http.Request, expected string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/biozz/biozz-dev-bot/internal/gpts"
+	"github.com/biozz/biozz-dev-bot/internal/jsonschema"
+	"github.com/pocketbase/dbx"
+	tele "gopkg.in/telebot.v4"
+)
+
+// schemaNameRe matches characters OpenAI doesn't allow in schema names
+var schemaNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// templateSystemPrompt asks for JSON, OpenAI rejects JSON mode requests
+// whose messages don't mention it
+const templateSystemPrompt = "Respond with a single JSON object only, without any other text."
+
+// handleRunTemplate runs a structured output template from the templates
+// collection against the replied-to message or the rest of the command.
+func (b *Bot) handleRunTemplate(c tele.Context) error {
+	args := strings.SplitN(strings.TrimSpace(c.Message().Payload), " ", 2)
+	name := args[0]
+	if name == "" {
+		return b.listTemplates(c)
+	}
+
+	record, err := b.app.FindFirstRecordByFilter("templates", "name = {:name}", dbx.Params{"name": name})
+	if err != nil {
+		return c.Reply(fmt.Sprintf("❌ Template %q not found", name))
+	}
+
+	text := repliedText(c.Message())
+	if text == "" && len(args) > 1 {
+		text = strings.TrimSpace(args[1])
+	}
+	if text == "" {
+		return c.Reply(fmt.Sprintf("Reply to a message or use /run %s <text>", name))
+	}
+
+	prompt, err := executeTemplate(name, record.GetString("prompt"), promptData{Text: text})
+	if err != nil {
+		b.app.Logger().Error("Error rendering template", "error", err, "template", name)
+		return c.Reply("❌ Unable to render template")
+	}
+
+	format := &gpts.ResponseFormat{
+		Name:   schemaNameRe.ReplaceAllString(name, "_"),
+		Strict: record.GetBool("strict"),
+	}
+
+	var schema *jsonschema.Schema
+	if raw := record.GetString("schema"); !isEmptyJSON(raw) {
+		schema, err = jsonschema.Parse([]byte(raw))
+		if err != nil {
+			return c.Reply(fmt.Sprintf("❌ Template %q has an invalid schema: %v", name, err))
+		}
+		format.Schema = json.RawMessage(raw)
+	}
+
+	model := record.GetString("model")
+	if model == "" {
+		model = b.summaryModel
+	}
+
+	c.Notify(tele.Typing)
+
+	provider := gpts.NewProvider(gpts.OpenAI, b.openAIAPIKey)
+	resp, err := provider.CreateChatCompletion(
+		context.Background(),
+		gpts.ChatCompletionRequest{
+			Model:  model,
+			System: templateSystemPrompt,
+			Messages: []gpts.ChatCompletionMessage{
+				{
+					Role:    string(gpts.RoleUser),
+					Content: prompt,
+				},
+			},
+			ResponseFormat: format,
+		},
+	)
+	if err != nil {
+		return c.Reply("chat completion error: " + err.Error())
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(resp.Message.Content), &value); err != nil {
+		b.app.Logger().Error("Model returned invalid JSON", "error", err, "template", name, "content", resp.Message.Content)
+		return c.Reply("❌ Model returned invalid JSON:\n\n" + resp.Message.Content)
+	}
+
+	if schema != nil {
+		if err := schema.Validate(value); err != nil {
+			b.app.Logger().Error("Model output doesn't match schema", "error", err, "template", name)
+			return c.Reply(fmt.Sprintf("❌ Output doesn't match the schema: %v\n\n%s", err, resp.Message.Content))
+		}
+	}
+
+	return c.Reply(formatStructured(value))
+}
+
+func (b *Bot) listTemplates(c tele.Context) error {
+	records, err := b.app.FindRecordsByFilter("templates", "", "name", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error getting templates", "error", err)
+		return c.Reply("❌ Error getting templates")
+	}
+	if len(records) == 0 {
+		return c.Reply("📭 No templates found")
+	}
+
+	lines := []string{"Usage: /run <template> [text]", ""}
+	for _, record := range records {
+		line := "• " + record.GetString("name")
+		if description := record.GetString("description"); description != "" {
+			line += " - " + description
+		}
+		lines = append(lines, line)
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+func isEmptyJSON(raw string) bool {
+	switch strings.TrimSpace(raw) {
+	case "", "null", `""`, "{}", "[]":
+		return true
+	default:
+		return false
+	}
+}
+
+// formatStructured renders decoded JSON as an indented plain text list
+func formatStructured(value any) string {
+	var sb strings.Builder
+	writeStructured(&sb, value, 0)
+	result := strings.TrimRight(sb.String(), "\n")
+	if result == "" {
+		return "📭 Nothing found"
+	}
+	return result
+}
+
+func writeStructured(sb *strings.Builder, value any, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if isScalar(v[k]) {
+				if s := formatScalar(v[k]); s != "" {
+					fmt.Fprintf(sb, "%s%s: %s\n", indent, humanizeKey(k), s)
+				}
+				continue
+			}
+			fmt.Fprintf(sb, "%s%s:\n", indent, humanizeKey(k))
+			writeStructured(sb, v[k], depth+1)
+		}
+	case []any:
+		for _, item := range v {
+			if isScalar(item) {
+				fmt.Fprintf(sb, "%s• %s\n", indent, formatScalar(item))
+				continue
+			}
+			if obj, ok := item.(map[string]any); ok {
+				fmt.Fprintf(sb, "%s• %s\n", indent, formatInline(obj))
+				continue
+			}
+			writeStructured(sb, item, depth+1)
+		}
+	default:
+		fmt.Fprintf(sb, "%s%s\n", indent, formatScalar(v))
+	}
+}
+
+// formatInline renders a flat object as a single line, nested values
+// fall back to JSON
+func formatInline(obj map[string]any) string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		var s string
+		if isScalar(obj[k]) {
+			s = formatScalar(obj[k])
+		} else {
+			data, _ := json.Marshal(obj[k])
+			s = string(data)
+		}
+		if s != "" {
+			parts = append(parts, fmt.Sprintf("%s: %s", humanizeKey(k), s))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isScalar(value any) bool {
+	switch value.(type) {
+	case map[string]any, []any:
+		return false
+	default:
+		return true
+	}
+}
+
+func formatScalar(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case float64:
+		return fmt.Sprintf("%g", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// humanizeKey turns snake_case keys into "Snake case"
+func humanizeKey(key string) string {
+	key = strings.ReplaceAll(key, "_", " ")
+	if key == "" {
+		return key
+	}
+	return strings.ToUpper(key[:1]) + key[1:]
+}
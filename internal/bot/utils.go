@@ -1,9 +1,13 @@
 package bot
 
 import (
+	"database/sql"
+	"errors"
 	"strings"
 
 	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	tele "gopkg.in/telebot.v4"
 )
 
 func EscapeTelegramMarkdown(text string) string {
@@ -18,7 +22,16 @@ func EscapeTelegramMarkdown(text string) string {
 func (b *Bot) setState(data map[string]any) error {
 	for key, value := range data {
 		state, err := b.app.FindFirstRecordByFilter("state", "key = {:key}", dbx.Params{"key": key})
-		if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Create missing keys on the fly, so new features don't need seed data
+			collection, err := b.app.FindCollectionByNameOrId("state")
+			if err != nil {
+				b.app.Logger().Error("Error finding state collection", "error", err)
+				return err
+			}
+			state = core.NewRecord(collection)
+			state.Set("key", key)
+		} else if err != nil {
 			b.app.Logger().Error("Error finding state", "error", err)
 			return err
 		}
@@ -31,11 +44,50 @@ func (b *Bot) setState(data map[string]any) error {
 	return nil
 }
 
+// getState returns an empty string for keys that were never set.
 func (b *Bot) getState(key string) (string, error) {
 	state, err := b.app.FindFirstRecordByFilter("state", "key = {:key}", dbx.Params{"key": key})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
 	if err != nil {
 		b.app.Logger().Error("Error finding state", "error", err)
 		return "", err
 	}
-	return state.Get("value").(string), nil
+	return state.GetString("value"), nil
+}
+
+// truncate shortens text to at most limit runes, marking the cut with an ellipsis.
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// repliedText returns the text of the message being replied to, preferring
+// the quoted part if there is one. Messages in forum topics reply to the
+// topic creation message implicitly, those are not treated as replies.
+func repliedText(msg *tele.Message) string {
+	if msg.Quote != nil && msg.Quote.Text != "" {
+		return msg.Quote.Text
+	}
+	reply := msg.ReplyTo
+	if reply == nil || reply.TopicCreated != nil {
+		return ""
+	}
+	if reply.Text != "" {
+		return reply.Text
+	}
+	return reply.Caption
+}
+
+// editMessage edits the callback message ignoring edits without changes
+func editMessage(c tele.Context, text string, keyboard *tele.ReplyMarkup) error {
+	err := c.Edit(text, keyboard)
+	if errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,266 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// webhookBodyLimit caps the size of accepted payloads
+	webhookBodyLimit = 1 << 20
+	// webhookRetention is how long received payloads are kept
+	webhookRetention = 30 * 24 * time.Hour
+)
+
+// Parse modes of the webhook_sources collection
+const (
+	webhookModeHTML     = "html"
+	webhookModeMarkdown = "markdown"
+)
+
+// errWebhookSkipped is returned for payloads the template renders empty
+var errWebhookSkipped = errors.New("template rendered an empty message")
+
+// startWebhookCleanup removes payloads older than webhookRetention once a day
+func (b *Bot) startWebhookCleanup() {
+	b.app.Cron().MustAdd("webhook_payloads", "30 3 * * *", func() {
+		before := types.NowDateTime().Add(-webhookRetention)
+		_, err := b.app.DB().Delete("webhook_payloads", dbx.NewExp("created < {:before}", dbx.Params{"before": before.String()})).Execute()
+		if err != nil {
+			b.app.Logger().Error("Error deleting old webhook payloads", "error", err)
+		}
+	})
+}
+
+// handleWebhook stores the payload of an enabled source and posts it to
+// the source topic. Payloads that are not JSON are stored as a string.
+func (b *Bot) handleWebhook(e *core.RequestEvent) error {
+	source, err := b.app.FindFirstRecordByFilter("webhook_sources", "name = {:name} && enabled = true", dbx.Params{"name": e.Request.PathValue("source")})
+	if err != nil {
+		return e.NotFoundError("Unknown webhook source", nil)
+	}
+	if !validToken(e.Request, source.GetString("token")) {
+		return e.UnauthorizedError("Invalid token", nil)
+	}
+
+	// One byte over the limit tells an oversized body from one that fits
+	body, err := io.ReadAll(io.LimitReader(e.Request.Body, webhookBodyLimit+1))
+	if err != nil {
+		return e.BadRequestError("Failed to read the request body", err)
+	}
+	if len(body) > webhookBodyLimit {
+		return e.Error(http.StatusRequestEntityTooLarge, "The payload is larger than 1MB", nil)
+	}
+
+	collection, err := b.app.FindCollectionByNameOrId("webhook_payloads")
+	if err != nil {
+		return e.InternalServerError("Failed to store the payload", err)
+	}
+	record := core.NewRecord(collection)
+	record.Set("source", source.Id)
+	if json.Valid(body) {
+		record.Set("payload", types.JSONRaw(body))
+	} else {
+		record.Set("payload", string(body))
+	}
+	if err := b.app.Save(record); err != nil {
+		b.app.Logger().Error("Error saving webhook payload", "error", err, "source", source.GetString("name"))
+		return e.InternalServerError("Failed to store the payload", nil)
+	}
+
+	msg, err := b.postWebhook(source, record)
+	if errors.Is(err, errWebhookSkipped) || errors.Is(err, errAlertMuted) {
+		return e.JSON(http.StatusOK, map[string]any{"id": record.Id, "skipped": true})
+	}
+	if err != nil {
+		b.app.Logger().Error("Error posting webhook payload", "error", err, "source", source.GetString("name"), "payload", record.Id)
+		return e.InternalServerError("Failed to post the payload: "+err.Error(), nil)
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{"id": record.Id, "message_id": msg.ID})
+}
+
+// postWebhook renders the stored payload with the current template of the
+// source and posts it, the result is saved on the payload record
+func (b *Bot) postWebhook(source, record *core.Record) (*tele.Message, error) {
+	var payload any
+	if err := record.UnmarshalJSONField("payload", &payload); err != nil {
+		return nil, err
+	}
+
+	text, mode, err := renderWebhook(source, payload)
+	var msg *tele.Message
+	if err == nil {
+		name := source.GetString("name")
+		msg, _, err = b.postAlert(alert{
+			source:   alertSourceWebhook,
+			key:      name,
+			severity: webhookSeverity(source, payload),
+			title:    name + ": " + plainTitle(text, mode),
+			loc:      b.alertsLocation(source.GetInt("thread_id")),
+			text:     text,
+			mode:     mode,
+		})
+	}
+
+	record.Set("error", "")
+	if err != nil {
+		record.Set("error", truncate(err.Error(), 1000))
+	}
+	if msg != nil {
+		record.Set("message_id", msg.ID)
+	}
+	if saveErr := b.app.Save(record); saveErr != nil {
+		b.app.Logger().Error("Error saving webhook payload", "error", saveErr, "payload", record.Id)
+	}
+
+	return msg, err
+}
+
+// webhookSeverity returns the severity field of the payload when it is a
+// known severity, otherwise the severity of the source
+func webhookSeverity(source *core.Record, payload any) string {
+	if fields, ok := payload.(map[string]any); ok {
+		if severity, ok := fields["severity"].(string); ok {
+			switch severity = strings.ToLower(severity); severity {
+			case alertInfo, alertWarning, alertCritical:
+				return severity
+			}
+		}
+	}
+	return source.GetString("severity")
+}
+
+// renderWebhook executes the template of the source with the payload as
+// data, sources without a template post the payload as formatted JSON
+func renderWebhook(source *core.Record, payload any) (string, tele.ParseMode, error) {
+	tmpl := source.GetString("template")
+	if strings.TrimSpace(tmpl) == "" {
+		data, err := json.MarshalIndent(payload, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		text := fmt.Sprintf("📨 <b>%s</b>\n<pre>%s</pre>",
+			html.EscapeString(source.GetString("name")), html.EscapeString(truncate(string(data), messageLimit)))
+		return text, tele.ModeHTML, nil
+	}
+
+	text, err := executeTemplate(source.Id, tmpl, payload)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render template: %w", err)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", "", errWebhookSkipped
+	}
+
+	var mode tele.ParseMode
+	switch source.GetString("parse_mode") {
+	case webhookModeHTML:
+		mode = tele.ModeHTML
+	case webhookModeMarkdown:
+		mode = tele.ModeMarkdown
+	}
+
+	// Cutting formatted text can break its markup, long messages are sent
+	// as plain text instead
+	if len([]rune(text)) > messageLimit && mode != "" {
+		if mode == tele.ModeHTML {
+			text = html.UnescapeString(htmlTagRe.ReplaceAllString(text, ""))
+		}
+		mode = ""
+	}
+	return truncate(text, messageLimit), mode, nil
+}
+
+// handleWebhooks lists webhook sources with their last payload, with
+// "replay" it posts a stored payload again using the current template
+func (b *Bot) handleWebhooks(c tele.Context) error {
+	args := strings.Fields(c.Message().Payload)
+	if len(args) > 0 && args[0] == "replay" {
+		if len(args) != 2 {
+			return c.Reply("Usage: /webhooks replay <payload_id|source>")
+		}
+		return b.replayWebhook(c, args[1])
+	}
+
+	sources, err := b.app.FindRecordsByFilter("webhook_sources", "", "name", 0, 0)
+	if err != nil {
+		b.app.Logger().Error("Error loading webhook sources", "error", err)
+		return c.Reply("❌ Error loading webhook sources")
+	}
+	if len(sources) == 0 {
+		return c.Reply("📭 No webhook sources, add them to the webhook_sources collection")
+	}
+
+	lines := []string{"📨 Webhook sources"}
+	for _, source := range sources {
+		line := "• " + source.GetString("name")
+		if !source.GetBool("enabled") {
+			line += " (disabled)"
+		}
+		last, err := b.lastWebhookPayload(source.Id)
+		switch {
+		case err != nil:
+			line += " · no payloads"
+		case last.GetString("error") != "":
+			line += fmt.Sprintf(" · %s ❌ %s (%s)", formatAgo(last.GetDateTime("created").Time()), truncate(last.GetString("error"), 100), last.Id)
+		default:
+			line += fmt.Sprintf(" · %s ✅ (%s)", formatAgo(last.GetDateTime("created").Time()), last.Id)
+		}
+		lines = append(lines, line)
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+// replayWebhook posts a payload by ID or the last payload of a source
+func (b *Bot) replayWebhook(c tele.Context, ref string) error {
+	record, err := b.app.FindRecordById("webhook_payloads", ref)
+	if err != nil {
+		source, sourceErr := b.app.FindFirstRecordByFilter("webhook_sources", "name = {:name}", dbx.Params{"name": ref})
+		if sourceErr != nil {
+			return c.Reply(fmt.Sprintf("❌ No payload or source %s", ref))
+		}
+		record, err = b.lastWebhookPayload(source.Id)
+		if err != nil {
+			return c.Reply(fmt.Sprintf("📭 No payloads of %s", ref))
+		}
+	}
+
+	source, err := b.app.FindRecordById("webhook_sources", record.GetString("source"))
+	if err != nil {
+		return c.Reply("❌ Webhook source not found")
+	}
+
+	if _, err := b.postWebhook(source, record); errors.Is(err, errWebhookSkipped) {
+		return c.Reply("📭 The template rendered an empty message")
+	} else if errors.Is(err, errAlertMuted) {
+		return c.Reply(fmt.Sprintf("🔕 Alerts of %s are muted", source.GetString("name")))
+	} else if err != nil {
+		b.app.Logger().Error("Error replaying webhook payload", "error", err, "payload", record.Id)
+		return c.Reply(fmt.Sprintf("❌ %v", err))
+	}
+	return c.Reply(fmt.Sprintf("✅ Replayed %s", record.Id))
+}
+
+func (b *Bot) lastWebhookPayload(sourceID string) (*core.Record, error) {
+	records, err := b.app.FindRecordsByFilter("webhook_payloads", "source = {:source}", "-created", 1, 0, dbx.Params{"source": sourceID})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no payloads")
+	}
+	return records[0], nil
+}
@@ -0,0 +1,400 @@
+// Package chart renders simple time series charts as PNG images
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultWidth      = 800
+	defaultPlotHeight = 320
+
+	marginLeft   = 64
+	marginRight  = 24
+	marginTop    = 36
+	marginBottom = 28
+
+	timelineRowHeight = 34
+	timelineBarHeight = 14
+	// lineHeight is the height of a text line of the font
+	lineHeight = 13
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	foreground = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	gridColor  = color.RGBA{0xe6, 0xe6, 0xe6, 0xff}
+	offColor   = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+
+	palette = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff},
+		{0xff, 0x7f, 0x0e, 0xff},
+		{0x2c, 0xa0, 0x2c, 0xff},
+		{0xd6, 0x27, 0x28, 0xff},
+		{0x94, 0x67, 0xbd, 0xff},
+		{0x8c, 0x56, 0x4b, 0xff},
+		{0xe3, 0x77, 0xc2, 0xff},
+		{0x17, 0xbe, 0xcf, 0xff},
+	}
+)
+
+// inactiveStates are drawn in gray on timelines
+var inactiveStates = map[string]bool{
+	"off":    true,
+	"closed": true,
+	"idle":   true,
+	"false":  true,
+}
+
+// Point is a value of a series at a point in time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a line of numeric values. Names and units are drawn with an
+// ASCII only font.
+type Series struct {
+	Name   string
+	Unit   string
+	Points []Point
+}
+
+// Segment is a period of time an entity spent in a state
+type Segment struct {
+	Start time.Time
+	End   time.Time
+	State string
+}
+
+// Timeline is rendered as a bar of state segments below the line chart
+type Timeline struct {
+	Name     string
+	Segments []Segment
+}
+
+// Chart describes the image, the line chart is omitted without series
+// and timelines are stacked below it
+type Chart struct {
+	Title     string
+	Start     time.Time
+	End       time.Time
+	Series    []Series
+	Timelines []Timeline
+	// Width defaults to 800 pixels
+	Width int
+}
+
+// Render writes the chart as a PNG image
+func Render(w io.Writer, c Chart) error {
+	if !c.End.After(c.Start) {
+		return fmt.Errorf("invalid chart period %s - %s", c.Start, c.End)
+	}
+	if len(c.Series) == 0 && len(c.Timelines) == 0 {
+		return fmt.Errorf("nothing to render")
+	}
+
+	width := c.Width
+	if width == 0 {
+		width = defaultWidth
+	}
+	plotHeight := 0
+	if len(c.Series) > 0 {
+		plotHeight = defaultPlotHeight
+	}
+	height := marginTop + plotHeight + len(c.Timelines)*timelineRowHeight + marginBottom
+
+	r := &renderer{
+		img:   image.NewRGBA(image.Rect(0, 0, width, height)),
+		start: c.Start,
+		end:   c.End,
+		left:  marginLeft,
+		right: width - marginRight,
+	}
+	draw.Draw(r.img, r.img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	r.text(marginLeft, lineHeight+4, c.Title, foreground)
+
+	bottom := marginTop + plotHeight + len(c.Timelines)*timelineRowHeight
+	r.timeAxis(marginTop, bottom)
+
+	if len(c.Series) > 0 {
+		r.legend(c.Series)
+		r.lines(c.Series, marginTop, marginTop+plotHeight)
+	}
+
+	for i, timeline := range c.Timelines {
+		r.timeline(timeline, marginTop+plotHeight+i*timelineRowHeight)
+	}
+
+	return png.Encode(w, r.img)
+}
+
+type renderer struct {
+	img         *image.RGBA
+	start, end  time.Time
+	left, right int
+}
+
+// x maps a time to the horizontal pixel position
+func (r *renderer) x(t time.Time) int {
+	ratio := float64(t.Sub(r.start)) / float64(r.end.Sub(r.start))
+	ratio = min(max(ratio, 0), 1)
+	return r.left + int(ratio*float64(r.right-r.left))
+}
+
+// text draws a string, the font only has ASCII glyphs so other
+// characters are dropped
+func (r *renderer) text(x, y int, s string, col color.Color) {
+	s = asciiOnly(s)
+	d := &font.Drawer{
+		Dst:  r.img,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func textWidth(s string) int {
+	return font.MeasureString(basicfont.Face7x13, asciiOnly(s)).Round()
+}
+
+func asciiOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func (r *renderer) rect(x0, y0, x1, y1 int, col color.Color) {
+	draw.Draw(r.img, image.Rect(x0, y0, x1, y1), image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// line draws a two pixel wide line using Bresenham's algorithm
+func (r *renderer) line(x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		r.img.Set(x0, y0, col)
+		r.img.Set(x0, y0+1, col)
+		r.img.Set(x0+1, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// timeAxis draws vertical grid lines with time labels below the bottom
+func (r *renderer) timeAxis(top, bottom int) {
+	step, layout := timeStep(r.end.Sub(r.start))
+	for t := alignTime(r.start, step); !t.After(r.end); t = t.Add(step) {
+		if t.Before(r.start) {
+			continue
+		}
+		x := r.x(t)
+		r.rect(x, top, x+1, bottom, gridColor)
+		label := t.Format(layout)
+		r.text(x-textWidth(label)/2, bottom+lineHeight+4, label, foreground)
+	}
+}
+
+func (r *renderer) legend(series []Series) {
+	x := r.right
+	for i := len(series) - 1; i >= 0; i-- {
+		label := series[i].Name
+		x -= textWidth(label)
+		r.text(x, lineHeight+4, label, foreground)
+		x -= 14
+		r.rect(x, 8, x+10, 18, palette[i%len(palette)])
+		x -= 12
+	}
+}
+
+// lines draws the series on a shared value axis between top and bottom
+func (r *renderer) lines(series []Series, top, bottom int) {
+	// Sensors can report NaN or infinity, such values are left out
+	finite := make([][]Point, len(series))
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, s := range series {
+		for _, p := range s.Points {
+			if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+				continue
+			}
+			finite[i] = append(finite[i], p)
+			lo, hi = min(lo, p.Value), max(hi, p.Value)
+		}
+	}
+	if math.IsInf(lo, 0) {
+		r.text(r.left+8, (top+bottom)/2, "no data", foreground)
+		return
+	}
+
+	step := niceStep((hi - lo) / 4)
+	lo = math.Floor(lo/step) * step
+	hi = math.Ceil(hi/step) * step
+	if hi == lo {
+		hi += step
+	}
+
+	// Leave some room above and below the lines
+	top, bottom = top+6, bottom-6
+	y := func(v float64) int {
+		return bottom - int((v-lo)/(hi-lo)*float64(bottom-top))
+	}
+
+	unit := series[0].Unit
+	for _, s := range series {
+		if s.Unit != unit {
+			unit = ""
+		}
+	}
+	for v := lo; v <= hi+step/2; v += step {
+		py := y(v)
+		r.rect(r.left, py, r.right, py+1, gridColor)
+		label := formatValue(v, step)
+		if unit != "" {
+			label += unit
+		}
+		r.text(r.left-textWidth(label)-6, py+lineHeight/2-2, label, foreground)
+	}
+
+	for i, points := range finite {
+		col := palette[i%len(palette)]
+		for j, p := range points {
+			x0, y0 := r.x(p.Time), y(p.Value)
+			// The last value holds until the end of the period
+			x1, y1 := r.right, y0
+			if j+1 < len(points) {
+				x1, y1 = r.x(points[j+1].Time), y(points[j+1].Value)
+			}
+			r.line(x0, y0, x1, y1, col)
+		}
+	}
+}
+
+// timeline draws the name of the timeline and a bar of its segments
+func (r *renderer) timeline(t Timeline, top int) {
+	r.text(r.left, top+lineHeight+2, t.Name, foreground)
+
+	barTop := top + lineHeight + 6
+	r.rect(r.left, barTop, r.right, barTop+timelineBarHeight, gridColor)
+
+	colors := make(map[string]color.RGBA)
+	for _, segment := range t.Segments {
+		if segment.State == "unavailable" || segment.State == "unknown" {
+			continue
+		}
+		col, ok := colors[segment.State]
+		if !ok {
+			col = offColor
+			if !inactiveStates[segment.State] {
+				col = palette[len(colors)%len(palette)]
+			}
+			colors[segment.State] = col
+		}
+		x0, x1 := r.x(segment.Start), r.x(segment.End)
+		r.rect(x0, barTop, max(x1, x0+1), barTop+timelineBarHeight, col)
+	}
+}
+
+// timeStep picks the interval between time labels and their layout
+func timeStep(span time.Duration) (time.Duration, string) {
+	steps := []time.Duration{
+		time.Hour,
+		2 * time.Hour,
+		3 * time.Hour,
+		6 * time.Hour,
+		12 * time.Hour,
+		24 * time.Hour,
+		2 * 24 * time.Hour,
+		7 * 24 * time.Hour,
+	}
+	for _, step := range steps {
+		if span/step <= 8 {
+			if step < 24*time.Hour {
+				return step, "15:04"
+			}
+			return step, "Jan 2"
+		}
+	}
+	return 30 * 24 * time.Hour, "Jan 2"
+}
+
+// alignTime rounds the time down to the step in its location, so labels
+// fall on full hours and midnights
+func alignTime(t time.Time, step time.Duration) time.Time {
+	if step >= 24*time.Hour {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	hours := int(step.Hours())
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()-t.Hour()%hours, 0, 0, 0, t.Location())
+}
+
+// niceStep rounds the step to 1, 2 or 5 times a power of ten
+func niceStep(step float64) float64 {
+	if step <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	switch fraction := step / magnitude; {
+	case fraction <= 1:
+		return magnitude
+	case fraction <= 2:
+		return 2 * magnitude
+	case fraction <= 5:
+		return 5 * magnitude
+	default:
+		return 10 * magnitude
+	}
+}
+
+func formatValue(v, step float64) string {
+	decimals := 0
+	if step < 1 {
+		decimals = int(math.Ceil(-math.Log10(step)))
+	}
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if s == "-0" || strings.Trim(s, "-0.") == "" {
+		return "0"
+	}
+	return s
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
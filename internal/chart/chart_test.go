@@ -0,0 +1,189 @@
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+)
+
+var (
+	testEnd   = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	testStart = testEnd.Add(-24 * time.Hour)
+)
+
+func render(t *testing.T, c Chart) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Render(&buf, c); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Render() wrote an invalid PNG: %v", err)
+	}
+	return img
+}
+
+func hasColor(img image.Image, col color.RGBA) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == col {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestRender(t *testing.T) {
+	var points []Point
+	for i := range 24 {
+		points = append(points, Point{Time: testStart.Add(time.Duration(i) * time.Hour), Value: 18 + float64(i%6)})
+	}
+
+	img := render(t, Chart{
+		Title: "Temperature",
+		Start: testStart,
+		End:   testEnd,
+		Series: []Series{
+			{Name: "Living room", Unit: "°C", Points: points},
+			{Name: "Bedroom", Unit: "°C", Points: points[:12]},
+		},
+		Timelines: []Timeline{{
+			Name: "Heating",
+			Segments: []Segment{
+				{Start: testStart, End: testStart.Add(6 * time.Hour), State: "heat"},
+				{Start: testStart.Add(6 * time.Hour), End: testStart.Add(8 * time.Hour), State: "unavailable"},
+				{Start: testStart.Add(8 * time.Hour), End: testEnd, State: "off"},
+			},
+		}},
+	})
+
+	want := image.Rect(0, 0, defaultWidth, marginTop+defaultPlotHeight+timelineRowHeight+marginBottom)
+	if img.Bounds() != want {
+		t.Fatalf("image bounds = %v, want %v", img.Bounds(), want)
+	}
+	for _, col := range []color.RGBA{palette[0], palette[1], offColor} {
+		if !hasColor(img, col) {
+			t.Errorf("image has no pixels of %v", col)
+		}
+	}
+}
+
+func TestRenderSparseInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		chart Chart
+	}{
+		{"series without points", Chart{Series: []Series{{Name: "Empty"}}}},
+		{"single point", Chart{Series: []Series{{Name: "One", Points: []Point{{Time: testStart.Add(time.Hour), Value: 21.5}}}}}},
+		{"constant zero", Chart{Series: []Series{{Name: "Zero", Points: []Point{{Time: testStart, Value: 0}, {Time: testEnd, Value: 0}}}}}},
+		{"non-finite values", Chart{Series: []Series{{Name: "Broken", Points: []Point{{Time: testStart, Value: math.NaN()}, {Time: testStart.Add(time.Hour), Value: 4}, {Time: testEnd, Value: math.Inf(1)}}}}}},
+		{"only NaN", Chart{Series: []Series{{Name: "NaN", Points: []Point{{Time: testStart, Value: math.NaN()}}}}}},
+		{"points outside the period", Chart{Series: []Series{{Name: "Late", Points: []Point{{Time: testEnd.Add(time.Hour), Value: -3}}}}}},
+		{"timeline without segments", Chart{Timelines: []Timeline{{Name: "Door"}}}},
+		{"reversed segment", Chart{Timelines: []Timeline{{Name: "Door", Segments: []Segment{{Start: testEnd, End: testStart, State: "open"}}}}}},
+		{"narrow image", Chart{Width: marginLeft + marginRight, Series: []Series{{Name: "One", Points: []Point{{Time: testStart, Value: 1}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.chart.Start, tt.chart.End = testStart, testEnd
+			render(t, tt.chart)
+		})
+	}
+}
+
+func TestRenderShortPeriod(t *testing.T) {
+	render(t, Chart{
+		Start:  testEnd.Add(-time.Minute),
+		End:    testEnd,
+		Series: []Series{{Name: "One", Points: []Point{{Time: testEnd.Add(-30 * time.Second), Value: 1}}}},
+	})
+}
+
+func TestRenderErrors(t *testing.T) {
+	series := []Series{{Name: "One", Points: []Point{{Time: testStart, Value: 1}}}}
+	tests := []struct {
+		name  string
+		chart Chart
+	}{
+		{"empty period", Chart{Start: testEnd, End: testEnd, Series: series}},
+		{"reversed period", Chart{Start: testEnd, End: testStart, Series: series}},
+		{"nothing to render", Chart{Start: testStart, End: testEnd}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Render(&buf, tt.chart); err == nil {
+				t.Fatal("Render() succeeded")
+			}
+		})
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct {
+		step, want float64
+	}{
+		{0, 1},
+		{-2, 1},
+		{0.03, 0.05},
+		{0.1, 0.1},
+		{1.5, 2},
+		{3, 5},
+		{7, 10},
+		{250, 500},
+	}
+
+	for _, tt := range tests {
+		if got := niceStep(tt.step); got != tt.want {
+			t.Errorf("niceStep(%v) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v, step float64
+		want    string
+	}{
+		{20, 5, "20"},
+		{0.25, 0.05, "0.25"},
+		{-0.0001, 0.5, "0"},
+		{-2, 1, "-2"},
+	}
+
+	for _, tt := range tests {
+		if got := formatValue(tt.v, tt.step); got != tt.want {
+			t.Errorf("formatValue(%v, %v) = %q, want %q", tt.v, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestTimeStep(t *testing.T) {
+	tests := []struct {
+		span       time.Duration
+		wantStep   time.Duration
+		wantLayout string
+	}{
+		{time.Minute, time.Hour, "15:04"},
+		{6 * time.Hour, time.Hour, "15:04"},
+		{24 * time.Hour, 3 * time.Hour, "15:04"},
+		{7 * 24 * time.Hour, 24 * time.Hour, "Jan 2"},
+		{365 * 24 * time.Hour, 30 * 24 * time.Hour, "Jan 2"},
+	}
+
+	for _, tt := range tests {
+		step, layout := timeStep(tt.span)
+		if step != tt.wantStep || layout != tt.wantLayout {
+			t.Errorf("timeStep(%s) = %s, %q, want %s, %q", tt.span, step, layout, tt.wantStep, tt.wantLayout)
+		}
+	}
+}
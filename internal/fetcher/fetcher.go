@@ -0,0 +1,141 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes limits how much of a response body is downloaded
+	DefaultMaxBytes int64 = 2 << 20
+	// DefaultMaxTextLength limits the extracted text, so it fits into the model context
+	DefaultMaxTextLength = 20000
+
+	userAgent = "Mozilla/5.0 (compatible; biozz-dev-bot/1.0)"
+)
+
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Page is the readable content extracted from a URL
+type Page struct {
+	URL         string
+	Title       string
+	Text        string
+	ContentType string
+	Truncated   bool
+}
+
+// Fetcher downloads a URL and extracts readable text from it
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (*Page, error)
+}
+
+type HTTPFetcher struct {
+	client        *http.Client
+	maxBytes      int64
+	maxTextLength int
+}
+
+type NewParams struct {
+	// Client defaults to an http.Client with a 15 second timeout
+	Client        *http.Client
+	MaxBytes      int64
+	MaxTextLength int
+}
+
+func New(params NewParams) *HTTPFetcher {
+	f := &HTTPFetcher{
+		client:        params.Client,
+		maxBytes:      params.MaxBytes,
+		maxTextLength: params.MaxTextLength,
+	}
+	if f.client == nil {
+		f.client = &http.Client{
+			Timeout: 15 * time.Second,
+		}
+	}
+	if f.maxBytes <= 0 {
+		f.maxBytes = DefaultMaxBytes
+	}
+	if f.maxTextLength <= 0 {
+		f.maxTextLength = DefaultMaxTextLength
+	}
+	return f
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) (*Page, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	if resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("response is too large: %d bytes", resp.ContentLength)
+	}
+
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = "text/html"
+	}
+	if !isSupported(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+
+	// One byte over the limit tells a cut body from one of exactly maxBytes
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{
+		URL:         resp.Request.URL.String(),
+		ContentType: contentType,
+	}
+	if int64(len(body)) > f.maxBytes {
+		body = body[:f.maxBytes]
+		page.Truncated = true
+	}
+
+	if contentType == "text/plain" {
+		page.Text = strings.TrimSpace(string(body))
+	} else {
+		page.Title, page.Text, err = extractText(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if runes := []rune(page.Text); len(runes) > f.maxTextLength {
+		page.Text = string(runes[:f.maxTextLength])
+		page.Truncated = true
+	}
+
+	return page, nil
+}
+
+func isSupported(contentType string) bool {
+	switch contentType {
+	case "text/html", "application/xhtml+xml", "text/plain":
+		return true
+	default:
+		return false
+	}
+}
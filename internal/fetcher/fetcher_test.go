@@ -0,0 +1,100 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newServer(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		// Flushing before the body makes the response chunked, without a Content-Length
+		w.(http.Flusher).Flush()
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantErr     bool
+	}{
+		{"text/html; charset=utf-8", false},
+		{"application/xhtml+xml", false},
+		{"text/plain", false},
+		{"application/pdf", true},
+		{"image/png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			srv := newServer(t, tt.contentType, "hello")
+			_, err := New(NewParams{}).Fetch(context.Background(), srv.URL)
+			if tt.wantErr != errors.Is(err, ErrUnsupportedContentType) {
+				t.Fatalf("Fetch() error = %v, want unsupported %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	srv := newServer(t, "text/plain", strings.Repeat("a", 100))
+
+	page, err := New(NewParams{MaxBytes: 10}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if len(page.Text) != 10 {
+		t.Errorf("len(Text) = %d, want 10", len(page.Text))
+	}
+	if !page.Truncated {
+		t.Error("Truncated = false, want true")
+	}
+}
+
+func TestFetchMaxBytesContentLength(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer srv.Close()
+
+	if _, err := New(NewParams{MaxBytes: 10}).Fetch(context.Background(), srv.URL); err == nil {
+		t.Fatal("Fetch() error = nil, want too large")
+	}
+}
+
+func TestFetchHTML(t *testing.T) {
+	body := `<html><head><title> Page title </title><style>p{}</style></head>
+<body><nav>Menu</nav><main><h1>Heading</h1><p>First <b>paragraph</b></p><script>alert(1)</script><p>Second</p></main>
+<footer>Footer</footer></body></html>`
+	srv := newServer(t, "text/html", body)
+
+	page, err := New(NewParams{}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if page.Title != "Page title" {
+		t.Errorf("Title = %q, want %q", page.Title, "Page title")
+	}
+	for _, want := range []string{"Heading", "First paragraph", "Second"} {
+		if !strings.Contains(page.Text, want) {
+			t.Errorf("Text = %q, want it to contain %q", page.Text, want)
+		}
+	}
+	for _, skipped := range []string{"Menu", "alert", "Footer", "p{}"} {
+		if strings.Contains(page.Text, skipped) {
+			t.Errorf("Text = %q, want it without %q", page.Text, skipped)
+		}
+	}
+}
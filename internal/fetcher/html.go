@@ -0,0 +1,130 @@
+package fetcher
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skipped are elements that never contain readable content
+var skipped = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Form:     true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Template: true,
+}
+
+// blocks are elements that start a new line in the extracted text
+var blocks = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Br:         true,
+	atom.Li:         true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+	atom.Tr:         true,
+	atom.Pre:        true,
+	atom.Blockquote: true,
+	atom.Section:    true,
+	atom.Article:    true,
+}
+
+// extractText returns the page title and its readable text. If the page
+// has an <article> or <main> element only its content is used.
+func extractText(body []byte) (string, string, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+
+	title := ""
+	if n := findFirst(doc, atom.Title); n != nil {
+		title = strings.TrimSpace(textContent(n))
+	}
+
+	root := findFirst(doc, atom.Article)
+	if root == nil {
+		root = findFirst(doc, atom.Main)
+	}
+	if root == nil {
+		root = findFirst(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var sb strings.Builder
+	walk(root, &sb)
+
+	return title, normalize(sb.String()), nil
+}
+
+func findFirst(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+	}
+	return sb.String()
+}
+
+func walk(n *html.Node, sb *strings.Builder) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if skipped[n.DataAtom] {
+			return
+		}
+	}
+
+	isBlock := n.Type == html.ElementNode && blocks[n.DataAtom]
+	if isBlock {
+		sb.WriteString("\n")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, sb)
+	}
+	if isBlock {
+		sb.WriteString("\n")
+	}
+}
+
+// normalize collapses whitespace within lines and drops empty lines
+func normalize(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
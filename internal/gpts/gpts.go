@@ -2,6 +2,7 @@ package gpts
 
 import (
 	"context"
+	"encoding/json"
 )
 
 const (
@@ -48,6 +49,16 @@ type ChatCompletionRequest struct {
 	Model    string
 	System   string
 	Messages []ChatCompletionMessage
+	// ResponseFormat is optional, the response is plain text if it is nil
+	ResponseFormat *ResponseFormat
+}
+
+// ResponseFormat requests JSON output from the model. Without a Schema
+// the model is only asked for a valid JSON object (JSON mode).
+type ResponseFormat struct {
+	Name   string
+	Schema json.RawMessage
+	Strict bool
 }
 
 type ChatCompletionResponse struct {
@@ -30,10 +30,11 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionReq
 		})
 	}
 	resp, err := c.client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
-			Model:    req.Model,
-			Messages: openaiMessages,
+			Model:          req.Model,
+			Messages:       openaiMessages,
+			ResponseFormat: openaiResponseFormat(req.ResponseFormat),
 		},
 	)
 	if err != nil {
@@ -47,3 +48,22 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionReq
 	}
 	return result, nil
 }
+
+func openaiResponseFormat(format *ResponseFormat) *openai.ChatCompletionResponseFormat {
+	if format == nil {
+		return nil
+	}
+	if len(format.Schema) == 0 {
+		return &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   format.Name,
+			Schema: format.Schema,
+			Strict: format.Strict,
+		},
+	}
+}
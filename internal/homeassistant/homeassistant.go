@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -16,19 +18,26 @@ type HomeAssistant struct {
 }
 
 type Device struct {
+	ID       string `json:"id" db:"id"`
 	EntityID string `json:"entity_id" db:"entity_id"`
 	Name     string `json:"name" db:"name"`
 	Type     string `json:"type" db:"type"`
-}
-
-type ServiceRequest struct {
-	EntityID string `json:"entity_id"`
+	Area     string `json:"area" db:"area"`
+	AreaID   string `json:"area_id" db:"area_id"`
+	Favorite bool   `json:"favorite" db:"favorite"`
+	// Confirm, AllowedHours and PINHash guard sensitive devices, the PIN
+	// is stored as a bcrypt hash
+	Confirm      bool   `json:"confirm" db:"confirm"`
+	AllowedHours string `json:"allowed_hours" db:"allowed_hours"`
+	PINHash      string `json:"-" db:"pin"`
 }
 
 type StateResponse struct {
-	EntityID   string                 `json:"entity_id"`
-	State      string                 `json:"state"`
-	Attributes map[string]interface{} `json:"attributes"`
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+	LastUpdated time.Time              `json:"last_updated"`
 }
 
 func New(baseURL, token string) *HomeAssistant {
@@ -62,11 +71,12 @@ func (ha *HomeAssistant) makeRequest(method, endpoint string, payload interface{
 	return ha.client.Do(req)
 }
 
-func (ha *HomeAssistant) TurnOn(entityID string) error {
-	deviceType := getDeviceType(entityID)
-	endpoint := fmt.Sprintf("/api/services/%s/turn_on", deviceType)
+// CallService calls a Home Assistant service with arbitrary service data,
+// the target entity is passed as "entity_id" in the data
+func (ha *HomeAssistant) CallService(domain, service string, data map[string]any) error {
+	endpoint := fmt.Sprintf("/api/services/%s/%s", domain, service)
 
-	resp, err := ha.makeRequest("POST", endpoint, ServiceRequest{EntityID: entityID})
+	resp, err := ha.makeRequest("POST", endpoint, data)
 	if err != nil {
 		return err
 	}
@@ -74,35 +84,115 @@ func (ha *HomeAssistant) TurnOn(entityID string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to turn on device: %s - %s", resp.Status, string(body))
+		return fmt.Errorf("failed to call %s.%s: %s - %s", domain, service, resp.Status, string(body))
 	}
 
 	return nil
 }
 
+func (ha *HomeAssistant) TurnOn(entityID string) error {
+	return ha.CallService(getDeviceType(entityID), "turn_on", map[string]any{"entity_id": entityID})
+}
+
 func (ha *HomeAssistant) TurnOff(entityID string) error {
-	deviceType := getDeviceType(entityID)
-	endpoint := fmt.Sprintf("/api/services/%s/turn_off", deviceType)
+	return ha.CallService(getDeviceType(entityID), "turn_off", map[string]any{"entity_id": entityID})
+}
 
-	resp, err := ha.makeRequest("POST", endpoint, ServiceRequest{EntityID: entityID})
+func (ha *HomeAssistant) Toggle(entityID string) error {
+	return ha.CallService(getDeviceType(entityID), "toggle", map[string]any{"entity_id": entityID})
+}
+
+func (ha *HomeAssistant) GetState(entityID string) (*StateResponse, error) {
+	endpoint := fmt.Sprintf("/api/states/%s", entityID)
+
+	resp, err := ha.makeRequest("GET", endpoint, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to turn off device: %s - %s", resp.Status, string(body))
+		return nil, fmt.Errorf("failed to get device state: %s - %s", resp.Status, string(body))
 	}
 
-	return nil
+	var state StateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
 }
 
-func (ha *HomeAssistant) Toggle(entityID string) error {
-	deviceType := getDeviceType(entityID)
-	endpoint := fmt.Sprintf("/api/services/%s/toggle", deviceType)
+// GetStates returns the states of all entities in a single request
+func (ha *HomeAssistant) GetStates() ([]StateResponse, error) {
+	resp, err := ha.makeRequest("GET", "/api/states", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get states: %s - %s", resp.Status, string(body))
+	}
+
+	var states []StateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&states); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func (ha *HomeAssistant) PressButton(entityID string) error {
+	return ha.CallService("button", "press", map[string]any{"entity_id": entityID})
+}
+
+// GetDomainStates returns the states of all entities of the domain
+func (ha *HomeAssistant) GetDomainStates(domain string) ([]StateResponse, error) {
+	states, err := ha.GetStates()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StateResponse
+	for _, state := range states {
+		if getDeviceType(state.EntityID) == domain {
+			result = append(result, state)
+		}
+	}
+	return result, nil
+}
+
+// GetCameraSnapshot returns the current still image of the camera
+func (ha *HomeAssistant) GetCameraSnapshot(entityID string) ([]byte, error) {
+	return ha.GetImage(fmt.Sprintf("/api/camera_proxy/%s", entityID))
+}
+
+// GetImage downloads an image served by Home Assistant, like a camera
+// proxy or a local media URL, the path is relative to the base URL
+func (ha *HomeAssistant) GetImage(path string) ([]byte, error) {
+	resp, err := ha.makeRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get image %s: %s - %s", path, resp.Status, string(body))
+	}
 
-	resp, err := ha.makeRequest("POST", endpoint, ServiceRequest{EntityID: entityID})
+	return io.ReadAll(resp.Body)
+}
+
+// FireEvent fires a custom event on the Home Assistant event bus, so
+// automations can wait for it with an event trigger
+func (ha *HomeAssistant) FireEvent(eventType string, data map[string]any) error {
+	endpoint := fmt.Sprintf("/api/events/%s", url.PathEscape(eventType))
+
+	resp, err := ha.makeRequest("POST", endpoint, data)
 	if err != nil {
 		return err
 	}
@@ -110,14 +200,27 @@ func (ha *HomeAssistant) Toggle(entityID string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to toggle device: %s - %s", resp.Status, string(body))
+		return fmt.Errorf("failed to fire event %s: %s - %s", eventType, resp.Status, string(body))
 	}
 
 	return nil
 }
 
-func (ha *HomeAssistant) GetState(entityID string) (*StateResponse, error) {
-	endpoint := fmt.Sprintf("/api/states/%s", entityID)
+// HistoryState is a state of an entity at a point in time
+type HistoryState struct {
+	State       string    `json:"state"`
+	LastChanged time.Time `json:"last_changed"`
+}
+
+// GetHistory returns state changes of the entities between start and end
+// keyed by entity ID, the first state of each entity is its state at start
+func (ha *HomeAssistant) GetHistory(entityIDs []string, start, end time.Time) (map[string][]HistoryState, error) {
+	query := url.Values{}
+	query.Set("filter_entity_id", strings.Join(entityIDs, ","))
+	query.Set("end_time", end.UTC().Format(time.RFC3339))
+	query.Set("minimal_response", "")
+	query.Set("no_attributes", "")
+	endpoint := fmt.Sprintf("/api/history/period/%s?%s", url.PathEscape(start.UTC().Format(time.RFC3339)), query.Encode())
 
 	resp, err := ha.makeRequest("GET", endpoint, nil)
 	if err != nil {
@@ -127,32 +230,111 @@ func (ha *HomeAssistant) GetState(entityID string) (*StateResponse, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get device state: %s - %s", resp.Status, string(body))
+		return nil, fmt.Errorf("failed to get history: %s - %s", resp.Status, string(body))
 	}
 
-	var state StateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+	// With minimal_response only the first state of each entity has the entity ID
+	var periods [][]struct {
+		EntityID string `json:"entity_id"`
+		HistoryState
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&periods); err != nil {
 		return nil, err
 	}
 
-	return &state, nil
+	history := make(map[string][]HistoryState, len(periods))
+	for _, period := range periods {
+		if len(period) == 0 {
+			continue
+		}
+		entityID := period[0].EntityID
+		for _, state := range period {
+			history[entityID] = append(history[entityID], state.HistoryState)
+		}
+	}
+
+	return history, nil
 }
 
-func (ha *HomeAssistant) PressButton(entityID string) error {
-	endpoint := "/api/services/button/press"
+// ServiceDomain lists the services of a domain as returned by /api/services
+type ServiceDomain struct {
+	Domain   string             `json:"domain"`
+	Services map[string]Service `json:"services"`
+}
+
+type Service struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Fields      map[string]ServiceField `json:"fields"`
+}
 
-	resp, err := ha.makeRequest("POST", endpoint, ServiceRequest{EntityID: entityID})
+type ServiceField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Example     any    `json:"example"`
+}
+
+func (ha *HomeAssistant) GetServices() ([]ServiceDomain, error) {
+	resp, err := ha.makeRequest("GET", "/api/services", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to press button: %s - %s", resp.Status, string(body))
+		return nil, fmt.Errorf("failed to get services: %s - %s", resp.Status, string(body))
 	}
 
-	return nil
+	var domains []ServiceDomain
+	if err := json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return nil, err
+	}
+
+	return domains, nil
+}
+
+// GetScriptFields returns the fields a script accepts as variables
+func (ha *HomeAssistant) GetScriptFields(entityID string) (map[string]ServiceField, error) {
+	domains, err := ha.GetServices()
+	if err != nil {
+		return nil, err
+	}
+
+	objectID := strings.TrimPrefix(entityID, "script.")
+	for _, domain := range domains {
+		if domain.Domain == "script" {
+			return domain.Services[objectID].Fields, nil
+		}
+	}
+	return nil, nil
+}
+
+func (ha *HomeAssistant) ActivateScene(entityID string) error {
+	return ha.CallService("scene", "turn_on", map[string]any{"entity_id": entityID})
+}
+
+// RunScript starts the script without waiting for it to finish
+func (ha *HomeAssistant) RunScript(entityID string, variables map[string]any) error {
+	data := map[string]any{"entity_id": entityID}
+	if len(variables) > 0 {
+		data["variables"] = variables
+	}
+	return ha.CallService("script", "turn_on", data)
+}
+
+func (ha *HomeAssistant) SetAutomationEnabled(entityID string, enabled bool) error {
+	service := "turn_off"
+	if enabled {
+		service = "turn_on"
+	}
+	return ha.CallService("automation", service, map[string]any{"entity_id": entityID})
+}
+
+// TriggerAutomation runs the actions of the automation, skipping its conditions
+func (ha *HomeAssistant) TriggerAutomation(entityID string) error {
+	return ha.CallService("automation", "trigger", map[string]any{"entity_id": entityID, "skip_condition": true})
 }
 
 // getDeviceType extracts the device type from entity_id (e.g., "light.living_room" -> "light")
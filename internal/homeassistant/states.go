@@ -0,0 +1,140 @@
+package homeassistant
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyAttributes are the attributes worth showing next to the state
+var keyAttributes = map[string][]string{
+	"light":        {"brightness", "color_temp_kelvin"},
+	"climate":      {"current_temperature", "temperature", "hvac_action"},
+	"cover":        {"current_position"},
+	"fan":          {"percentage", "preset_mode"},
+	"media_player": {"media_title", "volume_level", "source"},
+	"person":       {"source"},
+	"vacuum":       {"battery_level"},
+	"weather":      {"temperature", "humidity"},
+}
+
+// FindStates returns the states of entities matching the pattern sorted by
+// entity ID. The pattern is an entity ID, a domain like "light" or a glob
+// like "sensor.*_temperature".
+func (ha *HomeAssistant) FindStates(pattern string) ([]StateResponse, error) {
+	states, err := ha.GetStates()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StateResponse
+	for _, state := range states {
+		if MatchEntity(pattern, state.EntityID) {
+			result = append(result, state)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].EntityID < result[j].EntityID
+	})
+	return result, nil
+}
+
+// MatchEntity reports whether the entity ID matches an entity ID, domain
+// or glob pattern
+func MatchEntity(pattern, entityID string) bool {
+	if !strings.Contains(pattern, ".") && !strings.ContainsAny(pattern, "*?[") {
+		return getDeviceType(entityID) == pattern
+	}
+	matched, err := path.Match(pattern, entityID)
+	return err == nil && matched
+}
+
+// KeyAttributes returns the formatted key attributes of the entity domain
+// as "name value" pairs
+func (s StateResponse) KeyAttributes() []string {
+	var result []string
+	for _, key := range keyAttributes[getDeviceType(s.EntityID)] {
+		value, ok := s.Attributes[key]
+		if !ok || value == nil {
+			continue
+		}
+		result = append(result, fmt.Sprintf("%s %s", strings.ReplaceAll(key, "_", " "), FormatAttribute(key, value)))
+	}
+	return result
+}
+
+// Unit returns the unit of measurement of the state, if any
+func (s StateResponse) Unit() string {
+	unit, _ := s.Attributes["unit_of_measurement"].(string)
+	return unit
+}
+
+// FormatAttribute renders an attribute value for humans, brightness and
+// volume are converted to percent
+func FormatAttribute(key string, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case float64:
+		switch key {
+		case "brightness":
+			return fmt.Sprintf("%d%%", int(v/255*100+0.5))
+		case "volume_level":
+			return fmt.Sprintf("%d%%", int(v*100+0.5))
+		case "current_position", "percentage", "battery_level", "humidity":
+			return strconv.FormatFloat(v, 'f', -1, 64) + "%"
+		case "color_temp_kelvin":
+			return strconv.FormatFloat(v, 'f', 0, 64) + "K"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, FormatAttribute("", item))
+		}
+		return strings.Join(parts, ", ")
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// RenderTemplate renders a Jinja template on the Home Assistant side
+func (ha *HomeAssistant) RenderTemplate(template string) (string, error) {
+	resp, err := ha.makeRequest("POST", "/api/template", map[string]string{"template": template})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Template errors come back as a JSON message
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return "", fmt.Errorf("failed to render template: %s", apiErr.Message)
+		}
+		return "", fmt.Errorf("failed to render template: %s - %s", resp.Status, string(bytes.TrimSpace(body)))
+	}
+
+	return string(body), nil
+}
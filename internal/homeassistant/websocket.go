@@ -0,0 +1,500 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	wsReadLimit      = 16 << 20
+	wsPingInterval   = 30 * time.Second
+	wsCallTimeout    = 10 * time.Second
+	wsMinBackoff     = time.Second
+	wsMaxBackoff     = time.Minute
+	wsHandshakeLimit = 10 * time.Second
+)
+
+var ErrNotConnected = errors.New("home assistant websocket is not connected")
+
+// WebSocket is a client for the Home Assistant WebSocket API. It keeps
+// the connection alive, reconnects with backoff and restores
+// subscriptions after every reconnect.
+type WebSocket struct {
+	url    string
+	token  string
+	logger func() *slog.Logger
+
+	mu            sync.Mutex
+	conn          *websocket.Conn
+	nextID        int
+	pending       map[int]chan wsMessage
+	subscriptions []*subscription
+	// active maps message IDs of the current connection to subscriptions
+	active map[int]*subscription
+}
+
+type NewWebSocketParams struct {
+	// BaseURL is the same URL the REST client uses, e.g. http://homeassistant:8123
+	BaseURL string
+	Token   string
+	// Logger is resolved on every log call, so a logger that is only
+	// configured after the client is created can be passed
+	Logger func() *slog.Logger
+}
+
+type subscription struct {
+	msgType string
+	payload map[string]any
+	handler func(json.RawMessage)
+}
+
+type wsMessage struct {
+	ID          int             `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	Success     bool            `json:"success,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Event       json.RawMessage `json:"event,omitempty"`
+	Error       *WebSocketError `json:"error,omitempty"`
+	HAVersion   string          `json:"ha_version,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	AccessToken string          `json:"access_token,omitempty"`
+}
+
+// WebSocketError is an error returned by Home Assistant for a command
+type WebSocketError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WebSocketError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// StateChangedEvent is the data of a state_changed event. OldState is nil
+// for new entities and NewState is nil for removed ones.
+type StateChangedEvent struct {
+	EntityID string         `json:"entity_id"`
+	OldState *StateResponse `json:"old_state"`
+	NewState *StateResponse `json:"new_state"`
+}
+
+// EntitiesEvent is the compressed entity state update sent for
+// subscribe_entities. Keys are entity IDs, values use the short
+// attribute names: "s" state, "a" attributes, "lc" last changed and
+// "lu" last updated. Changes carry "+" with new and "-" with removed values.
+type EntitiesEvent struct {
+	Added   map[string]json.RawMessage `json:"a"`
+	Changed map[string]json.RawMessage `json:"c"`
+	Removed []string                   `json:"r"`
+}
+
+func NewWebSocket(params NewWebSocketParams) *WebSocket {
+	url := strings.TrimRight(params.BaseURL, "/") + "/api/websocket"
+	url = strings.Replace(url, "http://", "ws://", 1)
+	url = strings.Replace(url, "https://", "wss://", 1)
+
+	logger := params.Logger
+	if logger == nil {
+		logger = slog.Default
+	}
+
+	return &WebSocket{
+		url:     url,
+		token:   params.Token,
+		logger:  logger,
+		pending: make(map[int]chan wsMessage),
+		active:  make(map[int]*subscription),
+	}
+}
+
+// Run connects to Home Assistant and keeps the connection alive until
+// the context is cancelled.
+func (ws *WebSocket) Run(ctx context.Context) {
+	backoff := wsMinBackoff
+	for {
+		connected, err := ws.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = wsMinBackoff
+		}
+		ws.logger().Warn("Home Assistant websocket disconnected", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > wsMaxBackoff {
+			backoff = wsMaxBackoff
+		}
+	}
+}
+
+// Subscribe sends a subscription command and calls the handler with every
+// event it produces. Subscriptions are restored after reconnects. Handlers
+// are called from the read loop and should not block.
+func (ws *WebSocket) Subscribe(msgType string, payload map[string]any, handler func(json.RawMessage)) {
+	sub := &subscription{msgType: msgType, payload: payload, handler: handler}
+
+	ws.mu.Lock()
+	ws.subscriptions = append(ws.subscriptions, sub)
+	connected := ws.conn != nil
+	ws.mu.Unlock()
+
+	if connected {
+		ctx, cancel := context.WithTimeout(context.Background(), wsCallTimeout)
+		defer cancel()
+		if err := ws.subscribe(ctx, sub); err != nil {
+			ws.logger().Error("Error subscribing to Home Assistant", "error", err, "type", msgType)
+		}
+	}
+}
+
+// OnStateChanged calls the handler for every state_changed event
+func (ws *WebSocket) OnStateChanged(handler func(StateChangedEvent)) {
+	payload := map[string]any{"event_type": "state_changed"}
+	ws.Subscribe("subscribe_events", payload, func(raw json.RawMessage) {
+		var event struct {
+			Data StateChangedEvent `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			ws.logger().Error("Error decoding state_changed event", "error", err)
+			return
+		}
+		handler(event.Data)
+	})
+}
+
+// OnEntities calls the handler with compressed state updates of the given
+// entities, all entities are included if the list is empty. The first
+// event contains the current state of every entity.
+func (ws *WebSocket) OnEntities(entityIDs []string, handler func(EntitiesEvent)) {
+	var payload map[string]any
+	if len(entityIDs) > 0 {
+		payload = map[string]any{"entity_ids": entityIDs}
+	}
+	ws.Subscribe("subscribe_entities", payload, func(raw json.RawMessage) {
+		var event EntitiesEvent
+		if err := json.Unmarshal(raw, &event); err != nil {
+			ws.logger().Error("Error decoding entities event", "error", err)
+			return
+		}
+		handler(event)
+	})
+}
+
+// Call sends a command and waits for its result
+func (ws *WebSocket) Call(ctx context.Context, msgType string, payload map[string]any) (json.RawMessage, error) {
+	ws.mu.Lock()
+	conn := ws.conn
+	if conn == nil {
+		ws.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	ws.nextID++
+	id := ws.nextID
+	ch := make(chan wsMessage, 1)
+	ws.pending[id] = ch
+	ws.mu.Unlock()
+
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.pending, id)
+		ws.mu.Unlock()
+	}()
+
+	if err := ws.write(ctx, conn, command(id, msgType, payload)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		if msg.Type == "pong" {
+			return nil, nil
+		}
+		if !msg.Success {
+			if msg.Error != nil {
+				return nil, msg.Error
+			}
+			return nil, fmt.Errorf("command %s failed", msgType)
+		}
+		return msg.Result, nil
+	}
+}
+
+// connect runs a single connection until it fails, it reports whether
+// the authentication succeeded.
+func (ws *WebSocket) connect(ctx context.Context) (bool, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, wsHandshakeLimit)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(dialCtx, ws.url, nil)
+	if err != nil {
+		return false, err
+	}
+	conn.SetReadLimit(wsReadLimit)
+	defer conn.CloseNow()
+
+	if err := ws.authenticate(dialCtx, conn); err != nil {
+		return false, err
+	}
+
+	ws.mu.Lock()
+	ws.conn = conn
+	ws.active = make(map[int]*subscription)
+	subscriptions := append([]*subscription(nil), ws.subscriptions...)
+	ws.mu.Unlock()
+
+	defer ws.disconnect()
+
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer cancelConn()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- ws.readLoop(connCtx, conn)
+	}()
+
+	for _, sub := range subscriptions {
+		callCtx, cancel := context.WithTimeout(connCtx, wsCallTimeout)
+		err := ws.subscribe(callCtx, sub)
+		cancel()
+		if err != nil {
+			ws.logger().Error("Error subscribing to Home Assistant", "error", err, "type", sub.msgType)
+		}
+	}
+
+	ws.logger().Info("Home Assistant websocket connected", "url", ws.url)
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return true, ctx.Err()
+		case err := <-readErr:
+			return true, err
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(connCtx, wsCallTimeout)
+			_, err := ws.Call(pingCtx, "ping", nil)
+			cancel()
+			if err != nil {
+				return true, fmt.Errorf("ping failed: %w", err)
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) authenticate(ctx context.Context, conn *websocket.Conn) error {
+	msg, err := read(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("unexpected message %q, expected auth_required", msg.Type)
+	}
+
+	if err := ws.write(ctx, conn, wsMessage{Type: "auth", AccessToken: ws.token}); err != nil {
+		return err
+	}
+
+	msg, err = read(ctx, conn)
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case "auth_ok":
+		return nil
+	case "auth_invalid":
+		return fmt.Errorf("authentication failed: %s", msg.Message)
+	default:
+		return fmt.Errorf("unexpected message %q, expected auth_ok", msg.Type)
+	}
+}
+
+func (ws *WebSocket) subscribe(ctx context.Context, sub *subscription) error {
+	ws.mu.Lock()
+	conn := ws.conn
+	if conn == nil {
+		ws.mu.Unlock()
+		return ErrNotConnected
+	}
+	ws.nextID++
+	id := ws.nextID
+	ch := make(chan wsMessage, 1)
+	ws.pending[id] = ch
+	ws.active[id] = sub
+	ws.mu.Unlock()
+
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.pending, id)
+		ws.mu.Unlock()
+	}()
+
+	if err := ws.write(ctx, conn, command(id, sub.msgType, sub.payload)); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case msg, ok := <-ch:
+		if !ok {
+			return ErrNotConnected
+		}
+		if !msg.Success {
+			ws.mu.Lock()
+			delete(ws.active, id)
+			ws.mu.Unlock()
+			if msg.Error != nil {
+				return msg.Error
+			}
+			return fmt.Errorf("subscription %s failed", sub.msgType)
+		}
+		return nil
+	}
+}
+
+func (ws *WebSocket) readLoop(ctx context.Context, conn *websocket.Conn) error {
+	for {
+		msg, err := read(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case "event":
+			ws.mu.Lock()
+			sub := ws.active[msg.ID]
+			ws.mu.Unlock()
+			if sub != nil {
+				sub.handler(msg.Event)
+			}
+		case "result", "pong":
+			// Sending under the lock, so disconnect can't close the channel in between
+			ws.mu.Lock()
+			if ch, ok := ws.pending[msg.ID]; ok {
+				select {
+				case ch <- msg:
+				default:
+				}
+			}
+			ws.mu.Unlock()
+		}
+	}
+}
+
+// disconnect fails all pending calls of the current connection
+func (ws *WebSocket) disconnect() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.conn = nil
+	for id, ch := range ws.pending {
+		close(ch)
+		delete(ws.pending, id)
+	}
+}
+
+func (ws *WebSocket) write(ctx context.Context, conn *websocket.Conn, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, websocket.MessageText, data)
+}
+
+func read(ctx context.Context, conn *websocket.Conn) (wsMessage, error) {
+	var msg wsMessage
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// command builds a message with the payload fields on the top level,
+// which is how Home Assistant expects them
+func command(id int, msgType string, payload map[string]any) map[string]any {
+	msg := map[string]any{"id": id, "type": msgType}
+	for k, v := range payload {
+		msg[k] = v
+	}
+	return msg
+}
+
+// Area is an entry of the area registry
+type Area struct {
+	AreaID string `json:"area_id"`
+	Name   string `json:"name"`
+}
+
+// DeviceRegistryEntry is an entry of the device registry
+type DeviceRegistryEntry struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	NameByUser string `json:"name_by_user"`
+	AreaID     string `json:"area_id"`
+}
+
+// EntityRegistryEntry is an entry of the entity registry, entities
+// without a unique ID are not part of it
+type EntityRegistryEntry struct {
+	EntityID     string `json:"entity_id"`
+	Name         string `json:"name"`
+	OriginalName string `json:"original_name"`
+	AreaID       string `json:"area_id"`
+	DeviceID     string `json:"device_id"`
+	Platform     string `json:"platform"`
+	DisabledBy   string `json:"disabled_by"`
+	HiddenBy     string `json:"hidden_by"`
+}
+
+func (ws *WebSocket) ListAreas(ctx context.Context) ([]Area, error) {
+	var areas []Area
+	err := ws.callInto(ctx, "config/area_registry/list", &areas)
+	return areas, err
+}
+
+func (ws *WebSocket) ListDevices(ctx context.Context) ([]DeviceRegistryEntry, error) {
+	var devices []DeviceRegistryEntry
+	err := ws.callInto(ctx, "config/device_registry/list", &devices)
+	return devices, err
+}
+
+func (ws *WebSocket) ListEntities(ctx context.Context) ([]EntityRegistryEntry, error) {
+	var entities []EntityRegistryEntry
+	err := ws.callInto(ctx, "config/entity_registry/list", &entities)
+	return entities, err
+}
+
+func (ws *WebSocket) callInto(ctx context.Context, msgType string, result any) error {
+	raw, err := ws.Call(ctx, msgType, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, result)
+}
@@ -0,0 +1,242 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const testToken = "secret"
+
+// fakeHA is a Home Assistant WebSocket server that authenticates clients
+// and hands every authenticated connection to serve with its number
+type fakeHA struct {
+	*httptest.Server
+	conns atomic.Int32
+}
+
+func newFakeHA(t *testing.T, serve func(ctx context.Context, conn *websocket.Conn, n int)) *fakeHA {
+	t.Helper()
+	ha := &fakeHA{}
+	ha.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/websocket" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.CloseNow()
+		ctx := r.Context()
+
+		writeJSON(ctx, conn, map[string]any{"type": "auth_required", "ha_version": "2025.1.0"})
+		auth := readJSON(ctx, conn)
+		if auth == nil {
+			return
+		}
+		if auth["type"] != "auth" || auth["access_token"] != testToken {
+			writeJSON(ctx, conn, map[string]any{"type": "auth_invalid", "message": "Invalid access token"})
+			return
+		}
+		writeJSON(ctx, conn, map[string]any{"type": "auth_ok"})
+
+		serve(ctx, conn, int(ha.conns.Add(1)))
+	}))
+	t.Cleanup(ha.Close)
+	return ha
+}
+
+func writeJSON(ctx context.Context, conn *websocket.Conn, msg any) {
+	data, _ := json.Marshal(msg)
+	conn.Write(ctx, websocket.MessageText, data)
+}
+
+func readJSON(ctx context.Context, conn *websocket.Conn) map[string]any {
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		return nil
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil
+	}
+	return msg
+}
+
+func newTestWebSocket(t *testing.T, url, token string) (*WebSocket, context.Context) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return NewWebSocket(NewWebSocketParams{BaseURL: url, Token: token}), ctx
+}
+
+func waitConnected(t *testing.T, ws *WebSocket) {
+	t.Helper()
+	for range 200 {
+		ws.mu.Lock()
+		connected := ws.conn != nil
+		ws.mu.Unlock()
+		if connected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("websocket did not connect")
+}
+
+func TestWebSocketURL(t *testing.T) {
+	tests := map[string]string{
+		"http://homeassistant:8123":   "ws://homeassistant:8123/api/websocket",
+		"https://ha.example.com/":     "wss://ha.example.com/api/websocket",
+		"http://10.0.0.2:8123/prefix": "ws://10.0.0.2:8123/prefix/api/websocket",
+	}
+	for base, want := range tests {
+		if got := NewWebSocket(NewWebSocketParams{BaseURL: base}).url; got != want {
+			t.Errorf("url of %s = %s, want %s", base, got, want)
+		}
+	}
+}
+
+func TestWebSocketAuthInvalid(t *testing.T) {
+	ha := newFakeHA(t, func(ctx context.Context, conn *websocket.Conn, n int) {})
+	ws, ctx := newTestWebSocket(t, ha.URL, "wrong")
+
+	connected, err := ws.connect(ctx)
+	if connected {
+		t.Error("connected = true, want false")
+	}
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("connect() error = %v, want authentication failed", err)
+	}
+}
+
+func TestWebSocketCallCorrelation(t *testing.T) {
+	ha := newFakeHA(t, func(ctx context.Context, conn *websocket.Conn, n int) {
+		// Both commands are answered in reverse order
+		first, second := readJSON(ctx, conn), readJSON(ctx, conn)
+		if first == nil || second == nil {
+			return
+		}
+		for _, cmd := range []map[string]any{second, first} {
+			writeJSON(ctx, conn, map[string]any{
+				"id":      cmd["id"],
+				"type":    "result",
+				"success": true,
+				"result":  map[string]any{"type": cmd["type"]},
+			})
+		}
+		readJSON(ctx, conn)
+	})
+	ws, ctx := newTestWebSocket(t, ha.URL, testToken)
+	go ws.Run(ctx)
+	waitConnected(t, ws)
+
+	results := make(chan error, 2)
+	for _, msgType := range []string{"test/first", "test/second"} {
+		go func() {
+			raw, err := ws.Call(ctx, msgType, nil)
+			if err != nil {
+				results <- err
+				return
+			}
+			var result struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(raw, &result); err != nil {
+				results <- err
+				return
+			}
+			if result.Type != msgType {
+				t.Errorf("result of %s is for %s", msgType, result.Type)
+			}
+			results <- nil
+		}()
+	}
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatalf("Call() error = %v", err)
+		}
+	}
+}
+
+func TestWebSocketCallError(t *testing.T) {
+	ha := newFakeHA(t, func(ctx context.Context, conn *websocket.Conn, n int) {
+		cmd := readJSON(ctx, conn)
+		if cmd == nil {
+			return
+		}
+		writeJSON(ctx, conn, map[string]any{
+			"id":      cmd["id"],
+			"type":    "result",
+			"success": false,
+			"error":   map[string]any{"code": "not_found", "message": "Entity not found"},
+		})
+		readJSON(ctx, conn)
+	})
+	ws, ctx := newTestWebSocket(t, ha.URL, testToken)
+	go ws.Run(ctx)
+	waitConnected(t, ws)
+
+	_, err := ws.Call(ctx, "test/missing", nil)
+	wsErr, ok := err.(*WebSocketError)
+	if !ok || wsErr.Code != "not_found" {
+		t.Fatalf("Call() error = %v, want not_found", err)
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	ha := newFakeHA(t, func(ctx context.Context, conn *websocket.Conn, n int) {
+		cmd := readJSON(ctx, conn)
+		if cmd == nil || cmd["type"] != "subscribe_events" {
+			t.Errorf("connection %d: expected subscribe_events, got %v", n, cmd)
+			return
+		}
+		writeJSON(ctx, conn, map[string]any{"id": cmd["id"], "type": "result", "success": true})
+		writeJSON(ctx, conn, map[string]any{
+			"id":   cmd["id"],
+			"type": "event",
+			"event": map[string]any{"data": map[string]any{
+				"entity_id": "light.kitchen",
+				"new_state": map[string]any{"entity_id": "light.kitchen", "state": []string{"on", "off"}[n%2]},
+			}},
+		})
+		if n == 1 {
+			// The first connection drops right after the event
+			conn.Close(websocket.StatusGoingAway, "restart")
+			return
+		}
+		readJSON(ctx, conn)
+	})
+	ws, ctx := newTestWebSocket(t, ha.URL, testToken)
+
+	events := make(chan StateChangedEvent, 2)
+	ws.OnStateChanged(func(event StateChangedEvent) {
+		events <- event
+	})
+	go ws.Run(ctx)
+
+	var states []string
+	for range 2 {
+		select {
+		case event := <-events:
+			states = append(states, event.NewState.State)
+		case <-ctx.Done():
+			t.Fatalf("got events %v before the timeout, want 2", states)
+		}
+	}
+	if states[0] != "off" || states[1] != "on" {
+		t.Errorf("states = %v, want [off on]", states)
+	}
+	if n := ha.conns.Load(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
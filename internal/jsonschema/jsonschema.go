@@ -0,0 +1,202 @@
+// Package jsonschema validates decoded JSON values against the subset of
+// JSON Schema used for structured model output: type, properties, required,
+// additionalProperties, items, enum, minimum/maximum and minItems/maxItems.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+type Schema struct {
+	Type                 any                `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// ValidationError points to the part of the document that failed validation
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &s, nil
+}
+
+// Validate checks a value decoded with encoding/json against the schema
+func (s *Schema) Validate(value any) error {
+	return s.validate("$", value)
+}
+
+func (s *Schema) validate(path string, value any) error {
+	if types := s.types(); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if matchesType(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("expected %v, got %s", s.Type, typeOf(value))}
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("value %v is not one of %v", value, s.Enum)}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return s.validateObject(path, v)
+	case []any:
+		return s.validateArray(path, v)
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("%v is less than %v", v, *s.Minimum)}
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("%v is greater than %v", v, *s.Maximum)}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, obj map[string]any) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("missing required property %q", name)}
+		}
+	}
+
+	// Sorted for stable error messages
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		prop, ok := s.Properties[k]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("unexpected property %q", k)}
+			}
+			continue
+		}
+		if err := prop.validate(path+"."+k, obj[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(path string, arr []any) error {
+	if s.MinItems != nil && len(arr) < *s.MinItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at least %d items, got %d", *s.MinItems, len(arr))}
+	}
+	if s.MaxItems != nil && len(arr) > *s.MaxItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at most %d items, got %d", *s.MaxItems, len(arr))}
+	}
+	if s.Items == nil {
+		return nil
+	}
+	for i, item := range arr {
+		if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// types normalizes "type" which can be either a string or a list of strings
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, v := range t {
+			if str, ok := v.(string); ok {
+				types = append(types, str)
+			}
+		}
+		return types
+	default:
+		return nil
+	}
+}
+
+func matchesType(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return false
+	}
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
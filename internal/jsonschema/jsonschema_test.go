@@ -0,0 +1,117 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		document string
+		// wantPath is the path of the expected validation error, empty for
+		// valid documents
+		wantPath string
+	}{
+		{"string", `{"type": "string"}`, `"hello"`, ""},
+		{"not a string", `{"type": "string"}`, `42`, "$"},
+		{"number", `{"type": "number"}`, `4.5`, ""},
+		{"number as string", `{"type": "number"}`, `"4.5"`, "$"},
+		{"integer", `{"type": "integer"}`, `42`, ""},
+		{"integer with zero fraction", `{"type": "integer"}`, `42.0`, ""},
+		{"fraction as integer", `{"type": "integer"}`, `4.5`, "$"},
+		{"boolean", `{"type": "boolean"}`, `true`, ""},
+		{"boolean as string", `{"type": "boolean"}`, `"true"`, "$"},
+		{"null", `{"type": "null"}`, `null`, ""},
+		{"not null", `{"type": "null"}`, `0`, "$"},
+		{"type union", `{"type": ["string", "null"]}`, `null`, ""},
+		{"type union mismatch", `{"type": ["string", "null"]}`, `false`, "$"},
+		{"no type", `{}`, `{"anything": [1, "two"]}`, ""},
+
+		{"required present", `{"type": "object", "required": ["name"]}`, `{"name": "x"}`, ""},
+		{"required missing", `{"type": "object", "required": ["name"]}`, `{"other": "x"}`, "$"},
+		{"required null", `{"type": "object", "required": ["name"]}`, `{"name": null}`, ""},
+
+		{"properties", `{"type": "object", "properties": {"age": {"type": "integer"}}}`, `{"age": 30}`, ""},
+		{"property mismatch", `{"type": "object", "properties": {"age": {"type": "integer"}}}`, `{"age": "30"}`, "$.age"},
+		{"additional allowed", `{"type": "object", "properties": {"a": {}}}`, `{"a": 1, "b": 2}`, ""},
+		{"additional allowed explicitly", `{"properties": {"a": {}}, "additionalProperties": true}`, `{"b": 2}`, ""},
+		{"additional rejected", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, "$"},
+		{"no additional", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, ""},
+
+		{"enum", `{"enum": ["low", "high"]}`, `"low"`, ""},
+		{"not in enum", `{"enum": ["low", "high"]}`, `"medium"`, "$"},
+		{"numeric enum", `{"enum": [1, 2]}`, `2`, ""},
+		{"numeric enum mismatch", `{"enum": [1, 2]}`, `3`, "$"},
+
+		{"minimum", `{"type": "number", "minimum": 0}`, `0`, ""},
+		{"below minimum", `{"type": "number", "minimum": 0}`, `-1`, "$"},
+		{"maximum", `{"type": "number", "maximum": 10}`, `10`, ""},
+		{"above maximum", `{"type": "number", "maximum": 10}`, `10.5`, "$"},
+
+		{"min items", `{"type": "array", "minItems": 1}`, `[1]`, ""},
+		{"too few items", `{"type": "array", "minItems": 1}`, `[]`, "$"},
+		{"max items", `{"type": "array", "maxItems": 2}`, `[1, 2]`, ""},
+		{"too many items", `{"type": "array", "maxItems": 2}`, `[1, 2, 3]`, "$"},
+		{"items", `{"type": "array", "items": {"type": "string"}}`, `["a", "b"]`, ""},
+		{"item mismatch", `{"type": "array", "items": {"type": "string"}}`, `["a", 2]`, "$[1]"},
+
+		{
+			"nested valid",
+			`{"type": "object", "required": ["tasks"], "properties": {"tasks": {"type": "array", "items": {"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}, "priority": {"enum": ["low", "high"]}}, "additionalProperties": false}}}}`,
+			`{"tasks": [{"title": "a", "priority": "low"}, {"title": "b"}]}`,
+			"",
+		},
+		{
+			"nested invalid",
+			`{"type": "object", "required": ["tasks"], "properties": {"tasks": {"type": "array", "items": {"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}, "priority": {"enum": ["low", "high"]}}, "additionalProperties": false}}}}`,
+			`{"tasks": [{"title": "a"}, {"title": "b", "priority": "urgent"}]}`,
+			"$.tasks[1].priority",
+		},
+		{
+			"nested missing required",
+			`{"type": "object", "properties": {"tasks": {"type": "array", "items": {"type": "object", "required": ["title"]}}}}`,
+			`{"tasks": [{}]}`,
+			"$.tasks[0]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Parse([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			var document any
+			if err := json.Unmarshal([]byte(tt.document), &document); err != nil {
+				t.Fatalf("invalid test document: %v", err)
+			}
+
+			err = schema.Validate(document)
+			if tt.wantPath == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want a validation error", err)
+			}
+			if validationErr.Path != tt.wantPath {
+				t.Fatalf("Validate() error at %q, want %q: %v", validationErr.Path, tt.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse([]byte(`{"type": "object"`)); err == nil {
+		t.Fatal("Parse() of truncated JSON succeeded")
+	}
+	if _, err := Parse([]byte(`{"required": "name"}`)); err == nil {
+		t.Fatal("Parse() of a string required list succeeded")
+	}
+}
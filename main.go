@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	_ "github.com/biozz/biozz-dev-bot/migrations"
 
+	"github.com/biozz/biozz-dev-bot/internal/alertmanager"
 	"github.com/biozz/biozz-dev-bot/internal/bot"
+	"github.com/biozz/biozz-dev-bot/internal/fetcher"
 	ha "github.com/biozz/biozz-dev-bot/internal/homeassistant"
 	"github.com/biozz/biozz-dev-bot/internal/librechat"
 	"github.com/caarlos0/env/v11"
@@ -18,24 +22,37 @@ import (
 )
 
 type config struct {
-	TelegramBotToken   string `env:"TELEGRAM_BOT_TOKEN"`
-	SuperGroupID       int64  `env:"SUPERGROUP_ID"`
-	SuperUserID        int64  `env:"SUPERUSER_ID"`
-	GPTThreadID        int64  `env:"GPT_THREAD_ID"`
-	LibreChatMongoURI  string `env:"LIBRECHAT_MONGO_URI"`
-	LibreChatUserID    string `env:"LIBRECHAT_USER_ID"`
-	LibreChatTag       string `env:"LIBRECHAT_TAG"`
-	OpenAIAPIKey       string `env:"OPENAI_API_KEY"`
-	OpenRouterAPIKey   string `env:"OPENROUTER_API_KEY"`
-	ConvoModel         string `env:"CONVO_MODEL"`
-	SummaryModel       string `env:"SUMMARY_MODEL"`
-	HomeAssistantURL   string `env:"HOME_ASSISTANT_URL"`
-	HomeAssistantToken string `env:"HOME_ASSISTANT_TOKEN"`
+	TelegramBotToken   string        `env:"TELEGRAM_BOT_TOKEN"`
+	SuperGroupID       int64         `env:"SUPERGROUP_ID"`
+	SuperUserID        int64         `env:"SUPERUSER_ID"`
+	GPTThreadID        int64         `env:"GPT_THREAD_ID"`
+	LibreChatMongoURI  string        `env:"LIBRECHAT_MONGO_URI"`
+	LibreChatUserID    string        `env:"LIBRECHAT_USER_ID"`
+	LibreChatTag       string        `env:"LIBRECHAT_TAG"`
+	OpenAIAPIKey       string        `env:"OPENAI_API_KEY"`
+	OpenRouterAPIKey   string        `env:"OPENROUTER_API_KEY"`
+	ConvoModel         string        `env:"CONVO_MODEL"`
+	SummaryModel       string        `env:"SUMMARY_MODEL"`
+	HomeAssistantURL   string        `env:"HOME_ASSISTANT_URL"`
+	HomeAssistantToken string        `env:"HOME_ASSISTANT_TOKEN"`
+	InlineLog          bool          `env:"INLINE_LOG"`
+	HASyncDomains      string        `env:"HOME_ASSISTANT_SYNC_DOMAINS"`
+	HASyncCron         string        `env:"HOME_ASSISTANT_SYNC_CRON"`
+	HANotifyToken      string        `env:"HOME_ASSISTANT_NOTIFY_TOKEN"`
+	HAHealthCron       string        `env:"HOME_ASSISTANT_HEALTH_CRON"`
+	HABatteryThreshold float64       `env:"HOME_ASSISTANT_BATTERY_THRESHOLD"`
+	HAUnavailableAfter time.Duration `env:"HOME_ASSISTANT_UNAVAILABLE_AFTER"`
+	AlertmanagerURL    string        `env:"ALERTMANAGER_URL"`
+	AlertmanagerToken  string        `env:"ALERTMANAGER_TOKEN"`
+	AlertEscalateAfter time.Duration `env:"ALERT_ESCALATE_AFTER"`
 }
 
 func main() {
 	app := pocketbase.New()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	cfg, err := env.ParseAs[config]()
 	if err != nil {
 		app.Logger().Error("Failed to parse environment variables", "error", err)
@@ -55,11 +72,20 @@ func main() {
 	defer librechatClient.Cleanup()
 
 	haClient := ha.New(cfg.HomeAssistantURL, cfg.HomeAssistantToken)
+	haWebSocket := ha.NewWebSocket(ha.NewWebSocketParams{
+		BaseURL: cfg.HomeAssistantURL,
+		Token:   cfg.HomeAssistantToken,
+		// The PocketBase logger is only set up on bootstrap
+		Logger: app.Logger,
+	})
 
 	bot, err := bot.New(bot.NewBotParams{
 		App:                 app,
 		LibreChatClient:     librechatClient,
 		HomeAssistantClient: haClient,
+		HomeAssistantWS:     haWebSocket,
+		Alertmanager:        alertmanager.New(cfg.AlertmanagerURL),
+		Fetcher:             fetcher.New(fetcher.NewParams{}),
 		BotToken:            cfg.TelegramBotToken,
 		SuperGroupID:        cfg.SuperGroupID,
 		SuperUserID:         cfg.SuperUserID,
@@ -67,6 +93,19 @@ func main() {
 		OpenAIAPIKey:        cfg.OpenAIAPIKey,
 		OpenRouterAPIKey:    cfg.OpenRouterAPIKey,
 		SummaryModel:        cfg.SummaryModel,
+		ConvoModel:          cfg.ConvoModel,
+		InlineLog:           cfg.InlineLog,
+		// Home Assistant
+		HomeAssistantSyncDomains:      cfg.HASyncDomains,
+		HomeAssistantSyncCron:         cfg.HASyncCron,
+		HomeAssistantNotifyToken:      cfg.HANotifyToken,
+		HomeAssistantHealthCron:       cfg.HAHealthCron,
+		HomeAssistantBatteryThreshold: cfg.HABatteryThreshold,
+		HomeAssistantUnavailableAfter: cfg.HAUnavailableAfter,
+		// Alertmanager
+		AlertmanagerToken: cfg.AlertmanagerToken,
+		// Alerts
+		AlertEscalateAfter: cfg.AlertEscalateAfter,
 	})
 	if err != nil {
 		app.Logger().Error("Failed to create bot", "error", err)
@@ -75,12 +114,22 @@ func main() {
 
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 		se.Router.GET("/{path...}", apis.Static(os.DirFS("./pb_public"), false))
+		bot.RegisterRoutes(se)
 
 		go bot.Start()
 
+		if cfg.HomeAssistantURL != "" {
+			go haWebSocket.Run(ctx)
+		}
+
 		return se.Next()
 	})
 
+	app.OnTerminate().BindFunc(func(te *core.TerminateEvent) error {
+		cancel()
+		return te.Next()
+	})
+
 	isGoRun := strings.HasPrefix(os.Args[0], os.TempDir())
 	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
 		Automigrate: isGoRun,
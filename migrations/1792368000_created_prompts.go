@@ -0,0 +1,160 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1843675174",
+					"max": 0,
+					"min": 0,
+					"name": "description",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2539659139",
+					"max": 0,
+					"min": 0,
+					"name": "template",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_3415203736",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_SffoAlybnk` + "`" + ` ON ` + "`" + `prompts` + "`" + ` (` + "`" + `name` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "prompts",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// Seed default quick action prompts, they can be edited in the admin UI afterwards
+		defaults := []struct {
+			name        string
+			description string
+			template    string
+		}{
+			{
+				name:        "tldr",
+				description: "Summarize the replied-to message",
+				template:    "Summarize the following text in a few short bullet points. Answer in the language of the text.\n\n{{.Text}}",
+			},
+			{
+				name:        "translate",
+				description: "Translate the replied-to message, the language is the first argument",
+				template:    "Translate the following text to {{.Lang}}. Reply with the translation only.\n\n{{.Text}}",
+			},
+			{
+				name:        "explain",
+				description: "Explain the replied-to message in simple terms",
+				template:    "Explain the following text in simple terms, keep it short. Answer in the language of the text.\n\n{{.Text}}",
+			},
+			{
+				name:        "fix",
+				description: "Proofread the replied-to message",
+				template:    "Fix grammar, spelling and punctuation in the following text, keep the original language and tone. Reply with the corrected text only.\n\n{{.Text}}",
+			},
+		}
+		for _, d := range defaults {
+			record := core.NewRecord(collection)
+			record.Set("name", d.name)
+			record.Set("description", d.description)
+			record.Set("template", d.template)
+			if err := app.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3415203736")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
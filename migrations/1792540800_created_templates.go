@@ -0,0 +1,188 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1843675174",
+					"max": 0,
+					"min": 0,
+					"name": "description",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1659857976",
+					"max": 0,
+					"min": 0,
+					"name": "prompt",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "json3096330578",
+					"maxSize": 0,
+					"name": "schema",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3616895705",
+					"max": 0,
+					"min": 0,
+					"name": "model",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "bool2947452218",
+					"name": "strict",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1863781447",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_W9AvMnJ15R` + "`" + ` ON ` + "`" + `templates` + "`" + ` (` + "`" + `name` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "templates",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// Seed example templates, they can be edited in the admin UI afterwards
+		defaults := []struct {
+			name        string
+			description string
+			prompt      string
+			schema      string
+		}{
+			{
+				name:        "shopping_list",
+				description: "Extract a shopping list from the message",
+				prompt:      "Extract a shopping list from the following text. Use the language of the text.\n\n{{.Text}}",
+				schema:      `{"type":"object","properties":{"items":{"type":"array","items":{"type":"object","properties":{"name":{"type":"string"},"quantity":{"type":"string"}},"required":["name","quantity"],"additionalProperties":false}}},"required":["items"],"additionalProperties":false}`,
+			},
+			{
+				name:        "calendar_event",
+				description: "Extract a calendar event from the message",
+				prompt:      "Extract a calendar event from the following text. Use ISO 8601 for dates and times, leave unknown fields empty.\n\n{{.Text}}",
+				schema:      `{"type":"object","properties":{"title":{"type":"string"},"start":{"type":"string"},"end":{"type":"string"},"location":{"type":"string"},"notes":{"type":"string"}},"required":["title","start","end","location","notes"],"additionalProperties":false}`,
+			},
+		}
+		for _, d := range defaults {
+			record := core.NewRecord(collection)
+			record.Set("name", d.name)
+			record.Set("description", d.description)
+			record.Set("prompt", d.prompt)
+			record.Set("schema", d.schema)
+			record.Set("strict", true)
+			if err := app.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1863781447")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
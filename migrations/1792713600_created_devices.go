@@ -0,0 +1,246 @@
+package migrations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2166717789",
+					"max": 0,
+					"min": 0,
+					"name": "entity_id",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text599390211",
+					"max": 0,
+					"min": 0,
+					"name": "ha_name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2812878347",
+					"max": 0,
+					"min": 0,
+					"name": "domain",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3616816488",
+					"max": 0,
+					"min": 0,
+					"name": "area",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3171893404",
+					"max": 0,
+					"min": 0,
+					"name": "area_id",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number1361375778",
+					"max": null,
+					"min": null,
+					"name": "sort",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "bool2287856061",
+					"name": "hidden",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"hidden": false,
+					"id": "bool1757777625",
+					"name": "favorite",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"hidden": false,
+					"id": "bool4037049305",
+					"name": "missing",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_2153001328",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_eDkGrLwmqv` + "`" + ` ON ` + "`" + `devices` + "`" + ` (` + "`" + `entity_id` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "devices",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		// The collection used to be created by hand in the admin UI,
+		// in that case only the missing fields and indexes are added
+		existing, err := app.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return app.Save(collection)
+		}
+
+		// The unique entity_id index can't be created over empty or
+		// duplicate entity IDs, which of the rows to keep is up to the user
+		if existing.Fields.GetByName("entity_id") == nil {
+			total, err := app.CountRecords(existing)
+			if err != nil {
+				return err
+			}
+			if total > 0 {
+				return errors.New("devices: the existing records have no entity_id field, add it and fill it in before migrating")
+			}
+		} else {
+			var invalid []string
+			err := app.DB().NewQuery("SELECT [[entity_id]] FROM {{devices}} GROUP BY [[entity_id]] HAVING COUNT(*) > 1 OR [[entity_id]] = ''").Column(&invalid)
+			if err != nil {
+				return err
+			}
+			if len(invalid) > 0 {
+				return fmt.Errorf("devices: fix the empty or duplicate entity_id values before migrating: %q", invalid)
+			}
+		}
+
+		for _, field := range collection.Fields {
+			if existing.Fields.GetByName(field.GetName()) == nil {
+				existing.Fields.Add(field)
+			}
+		}
+		for _, index := range collection.Indexes {
+			existing.Indexes = append(existing.Indexes, index)
+		}
+
+		return app.Save(existing)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return err
+		}
+
+		// A collection created by hand keeps its fields and records, the
+		// fields can't be told apart from the added ones
+		if collection.Id != "pbc_2153001328" {
+			collection.RemoveIndex("idx_eDkGrLwmqv")
+			return app.Save(collection)
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// The collection might have been created by hand with another id
+		collection, err := app.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(11, []byte(`{
+			"hidden": false,
+			"id": "bool2413011780",
+			"name": "confirm",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "bool"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(12, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1237034837",
+			"max": 0,
+			"min": 0,
+			"name": "allowed_hours",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(13, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": true,
+			"id": "text3045404147",
+			"max": 0,
+			"min": 0,
+			"name": "pin",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("bool2413011780")
+
+		// remove field
+		collection.Fields.RemoveById("text1237034837")
+
+		// remove field
+		collection.Fields.RemoveById("text3045404147")
+
+		return app.Save(collection)
+	})
+}
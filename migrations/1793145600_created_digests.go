@@ -0,0 +1,204 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "bool1358543748",
+					"name": "enabled",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text399311096",
+					"max": 0,
+					"min": 0,
+					"name": "cron",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number3801104409",
+					"max": null,
+					"min": null,
+					"name": "thread_id",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "select731267992",
+					"maxSelect": 5,
+					"name": "sections",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "select",
+					"values": [
+						"presence",
+						"energy",
+						"temperature",
+						"devices",
+						"batteries"
+					]
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3507965122",
+					"max": 0,
+					"min": 0,
+					"name": "energy_entities",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text122977451",
+					"max": 0,
+					"min": 0,
+					"name": "temperature_entity",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number2639417438",
+					"max": null,
+					"min": null,
+					"name": "battery_threshold",
+					"onlyInt": false,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "date1914336906",
+					"max": "",
+					"min": "",
+					"name": "last_run",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1047269131",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_jXPWw78tHR` + "`" + ` ON ` + "`" + `digests` + "`" + ` (` + "`" + `name` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "digests",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// Seed a disabled morning digest with all sections, it can be enabled in the admin UI
+		record := core.NewRecord(collection)
+		record.Set("name", "morning")
+		record.Set("cron", "0 8 * * *")
+		record.Set("sections", []string{"presence", "energy", "temperature", "devices", "batteries"})
+		record.Set("battery_threshold", 20)
+		return app.Save(record)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1047269131")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
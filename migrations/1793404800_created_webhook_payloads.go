@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_4240456554",
+					"hidden": false,
+					"id": "relation1602912115",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "source",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "json1110206997",
+					"maxSize": 0,
+					"name": "payload",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"hidden": false,
+					"id": "number1400509225",
+					"max": null,
+					"min": null,
+					"name": "message_id",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1574812785",
+					"max": 0,
+					"min": 0,
+					"name": "error",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_2288746914",
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_5KQWEiOCHg` + "`" + ` ON ` + "`" + `webhook_payloads` + "`" + ` (` + "`" + `source` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_fc11Hxbyvr` + "`" + ` ON ` + "`" + `webhook_payloads` + "`" + ` (` + "`" + `created` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "webhook_payloads",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_2288746914")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		records, err := app.FindRecordsByFilter("devices", "pin != ''", "", 0, 0)
+		if err != nil {
+			return err
+		}
+
+		// PINs were stored as plain text, they are replaced with bcrypt hashes
+		for _, record := range records {
+			pin := record.GetString("pin")
+			if _, err := bcrypt.Cost([]byte(pin)); err == nil {
+				continue
+			}
+			hashed, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			record.Set("pin", string(hashed))
+			if err := app.SaveNoValidate(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// Hashed PINs can't be restored
+		return nil
+	})
+}